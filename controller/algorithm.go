@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+)
+
+// CreateRun creates a run for any registered algorithm family.
+// The family is taken from the {type} path segment, e.g. /api/ea.
+func CreateRun(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+
+	algoType := req.PathValue("type")
+	logger.Info(fmt.Sprintf("CreateRun API called for %s.", algoType))
+
+	if !modules.IsAlgorithmType(algoType) {
+		util.JSONResponse(res, http.StatusNotFound, fmt.Sprintf("unknown algorithm type: %s", algoType), nil)
+		return
+	}
+
+	user, ok := authorize(res, req, modules.ActionRunCreate)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	algo, err := modules.AlgorithmFromJSON(algoType, data)
+	if err != nil {
+		algorithmError(res, err)
+		return
+	}
+
+	runID, err := modules.SubmitRun(req.Context(), algo, user.ID, logger)
+	if err != nil {
+		if modules.IsSpecError(err) {
+			algorithmError(res, err)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	data["runID"] = runID
+	data["seed"] = algo.RunSeed()
+	util.JSONResponse(res, http.StatusOK, "It works! 👍🏻", data)
+}
+
+// PreviewRun validates an algorithm spec and returns the generated code
+// together with the normalized input, without creating or queuing a run.
+func PreviewRun(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+
+	algoType := req.PathValue("type")
+	logger.Info(fmt.Sprintf("PreviewRun API called for %s.", algoType))
+
+	if !modules.IsAlgorithmType(algoType) {
+		util.JSONResponse(res, http.StatusNotFound, fmt.Sprintf("unknown algorithm type: %s", algoType), nil)
+		return
+	}
+
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	algo, err := modules.AlgorithmFromJSON(algoType, data)
+	if err != nil {
+		algorithmError(res, err)
+		return
+	}
+
+	code, err := algo.Code()
+	if err != nil {
+		algorithmError(res, err)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Run preview", map[string]any{
+		"type":  algoType,
+		"code":  code,
+		"input": algo,
+	})
+}
+
+// algorithmError reports an invalid algorithm spec. Validation errors are
+// returned together as a list of {field, message} objects.
+func algorithmError(res http.ResponseWriter, err error) {
+	var errs util.ValidationErrors
+	if errors.As(err, &errs) {
+		util.JSONResponse(res, http.StatusUnprocessableEntity, "invalid algorithm parameters", errs)
+		return
+	}
+	util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+)
+
+// CreateAPIKey creates a personal API key for scripts and CI jobs.
+// The key is only ever shown in this response.
+func CreateAPIKey(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("CreateAPIKey API called.")
+
+	user, ok := apiKeyOwner(res, req)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	key, err := modules.APIKeyReqFromJSON(data)
+	if err != nil {
+		apiKeyError(res, err)
+		return
+	}
+
+	created, err := key.CreateAPIKey(req.Context(), user, logger)
+	if err != nil {
+		var errs util.ValidationErrors
+		if errors.As(err, &errs) {
+			apiKeyError(res, err)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusCreated, "API key created", created)
+}
+
+// UserAPIKeys lists the API keys of the user that have not been revoked.
+func UserAPIKeys(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("UserAPIKeys API called.")
+
+	user, ok := apiKeyOwner(res, req)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	keys, err := modules.UserAPIKeys(req.Context(), user.ID, logger)
+	if err != nil {
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "User API keys", keys)
+}
+
+// RevokeAPIKey revokes an API key of the user.
+// The key is taken from the {id} path segment.
+func RevokeAPIKey(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("RevokeAPIKey API called.")
+
+	user, ok := apiKeyOwner(res, req)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	if err := modules.RevokeAPIKey(req.Context(), req.PathValue("id"), user.ID, logger); err != nil {
+		if errors.Is(err, modules.ErrAPIKeyNotFound) {
+			util.JSONResponse(res, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "API key revoked", nil)
+}
+
+// apiKeyOwner returns the user of the request, unless the request was made
+// with an API key: keys cannot be used to create or revoke other keys.
+func apiKeyOwner(res http.ResponseWriter, req *http.Request) (*modules.User, bool) {
+	user, ok := authorize(res, req, modules.ActionAPIKeyManage)
+	if !ok {
+		return nil, false
+	}
+	if user.APIKeyID != "" {
+		util.JSONResponse(res, http.StatusForbidden, "API keys cannot be managed with an API key", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+func apiKeyError(res http.ResponseWriter, err error) {
+	var errs util.ValidationErrors
+	if errors.As(err, &errs) {
+		util.JSONResponse(res, http.StatusUnprocessableEntity, "invalid api key", errs)
+		return
+	}
+	util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+}
@@ -0,0 +1,23 @@
+package controller
+
+import (
+	"evolve/modules"
+	"evolve/util"
+	"net/http"
+)
+
+// authorize returns the user of the request if the role of the user allows
+// the action, and responds with 401 or 403 otherwise. Handlers must be
+// registered as protected routes.
+func authorize(res http.ResponseWriter, req *http.Request, action string) (*modules.User, bool) {
+	user, ok := modules.UserFromContext(req.Context())
+	if !ok {
+		util.JSONResponse(res, http.StatusUnauthorized, "unauthorized", nil)
+		return nil, false
+	}
+	if err := modules.Authorize(user, action); err != nil {
+		util.JSONResponse(res, http.StatusForbidden, err.Error(), nil)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"errors"
+	"evolve/storage"
+	"evolve/util"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+)
+
+// safeContentTypes are the types files are served as. Anything else (e.g.
+// HTML or SVG uploaded by a run) is served as application/octet-stream,
+// so that it cannot run scripts on the origin of the API.
+var safeContentTypes = []string{
+	"application/gzip",
+	"application/json",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"text/csv",
+	"text/plain",
+	"text/x-python",
+}
+
+// fileContentType returns the content type a file is served with.
+func fileContentType(name string) string {
+	contentType := storage.ContentType(name)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && slices.Contains(safeContentTypes, mediaType) {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// File serves a file of the local or memory store to the
+// holder of a presigned URL, which replaces authentication.
+// Files are always downloaded rather than displayed.
+func File(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("File API called.")
+
+	name := req.PathValue("name")
+	if err := storage.VerifyFileToken(name, req.URL.Query().Get("token")); err != nil {
+		util.JSONResponse(res, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+
+	content, err := storage.Get(req.Context(), name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			util.JSONResponse(res, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, "something went wrong", nil)
+		return
+	}
+
+	res.Header().Set("Content-Type", fileContentType(name))
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusOK)
+	res.Write(content)
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"evolve/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileHeaders(t *testing.T) {
+	store := storage.NewMemoryStore("http://localhost:5002")
+	storage.Use(store)
+
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"run/code.py", "text/x-python"},
+		{"run/plots/graph.png", "image/png"},
+		{"run/report.html", "application/octet-stream"},
+		{"run/plots/graph.svg", "application/octet-stream"},
+		{"run/script.js", "application/octet-stream"},
+		{"run/data", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Put(ctx, tt.name, strings.NewReader("content"), -1, storage.ContentType(tt.name)); err != nil {
+				t.Fatal(err)
+			}
+			presigned, err := store.PresignGet(ctx, tt.name, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(presigned)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+			req.SetPathValue("name", tt.name)
+			res := httptest.NewRecorder()
+			File(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatalf("File returned %d: %s", res.Code, res.Body)
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := res.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment;") {
+				t.Errorf("Content-Disposition = %q, want an attachment", got)
+			}
+			if got := res.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+		})
+	}
+}
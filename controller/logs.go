@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+)
+
+// DownloadLogs returns the whole output of a run as a file, as text,
+// NDJSON or gzip (?format=), optionally only stdout or stderr (?stream=).
+func DownloadLogs(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("DownloadLogs API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	query := req.URL.Query()
+	drq := &modules.DownloadLogsReq{
+		RunID:  query.Get("runId"),
+		Stream: query.Get("stream"),
+		Format: query.Get("format"),
+	}
+	if err := drq.Validate(); err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if _, err := modules.RunAccessMode(req.Context(), drq.RunID, user, logger); err != nil {
+		runAccessError(res, err)
+		return
+	}
+
+	// Headers are only sent with the first bytes of the logs,
+	// so that errors before that are still reported as JSON.
+	w := &downloadWriter{res: res, contentType: drq.ContentType(), fileName: drq.FileName()}
+	err := drq.DownloadLogs(req.Context(), w, logger)
+	switch {
+	case err == nil && !w.started:
+		// Only the EOF marker, or nothing of the requested stream.
+		w.start()
+	case err == nil:
+	case w.started:
+		logger.Error(fmt.Sprintf("DownloadLogs: download of %s aborted: %v", drq.RunID, err))
+	case errors.Is(err, modules.ErrLogsNotFound):
+		util.JSONResponse(res, http.StatusNotFound, err.Error(), nil)
+	default:
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+	}
+}
+
+// downloadWriter sends the download headers before the first write.
+type downloadWriter struct {
+	res         http.ResponseWriter
+	contentType string
+	fileName    string
+	started     bool
+}
+
+func (d *downloadWriter) start() {
+	d.started = true
+	d.res.Header().Set("Content-Type", d.contentType)
+	d.res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.fileName))
+	d.res.WriteHeader(http.StatusOK)
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.started {
+		d.start()
+	}
+	return d.res.Write(p)
+}
@@ -1,23 +1,22 @@
 package controller
 
 import (
+	"errors"
 	"evolve/modules"
 	"evolve/util"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 func UserRun(res http.ResponseWriter, req *http.Request) {
 	var logger = util.NewLogger()
 	logger.Info("UserRuns API called.")
 
-	user, err := modules.Auth(req)
-	if err != nil {
-		util.JSONResponse(res, http.StatusUnauthorized, err.Error(), nil)
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
 		return
 	}
-
-	// User has id, role, userName, email & fullName.
 	logger.Info(fmt.Sprintf("User: %s", user))
 
 	data, err := util.Body(req)
@@ -34,30 +33,39 @@ func UserRun(res http.ResponseWriter, req *http.Request) {
 
 	logger.Info(fmt.Sprintf("Run: %s", run.RunID))
 
-	runData, err := run.UserRun(req.Context(), user["id"], logger)
+	runData, err := run.UserRun(req.Context(), user, logger)
 	if err != nil {
-		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		runAccessError(res, err)
 		return
 	}
 
 	util.JSONResponse(res, http.StatusOK, "User run", runData)
 }
 
+// UserRuns lists the runs the user has access to,
+// or every run with ?all=true if the role allows it.
 func UserRuns(res http.ResponseWriter, req *http.Request) {
 	var logger = util.NewLogger()
 	logger.Info("UserRuns API called.")
 
-	user, err := modules.Auth(req)
-	if err != nil {
-		util.JSONResponse(res, http.StatusUnauthorized, err.Error(), nil)
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
 		return
 	}
-
-	// User has id, role, userName, email & fullName.
 	logger.Info(fmt.Sprintf("User: %s", user))
 
-	runs, err := modules.UserRuns(req.Context(), user["id"], logger)
+	var runs []map[string]string
+	var err error
+	if all, _ := strconv.ParseBool(req.URL.Query().Get("all")); all {
+		runs, err = modules.AllRuns(req.Context(), user, logger)
+	} else {
+		runs, err = modules.UserRuns(req.Context(), user.ID, logger)
+	}
 	if err != nil {
+		if isRunAccessError(err) {
+			runAccessError(res, err)
+			return
+		}
 		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
 		return
 	}
@@ -69,13 +77,10 @@ func ShareRun(res http.ResponseWriter, req *http.Request) {
 	var logger = util.NewLogger()
 	logger.Info("ShareRun API called.")
 
-	user, err := modules.Auth(req)
-	if err != nil {
-		util.JSONResponse(res, http.StatusUnauthorized, err.Error(), nil)
+	user, ok := authorize(res, req, modules.ActionRunShare)
+	if !ok {
 		return
 	}
-
-	// User has id, role, userName, email & fullName.
 	logger.Info(fmt.Sprintf("User: %s", user))
 
 	data, err := util.Body(req)
@@ -90,10 +95,174 @@ func ShareRun(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := srq.ShareRun(req.Context(), logger); err != nil {
+	if err := srq.ShareRun(req.Context(), user, logger); err != nil {
+		if isRunAccessError(err) {
+			runAccessError(res, err)
+			return
+		}
 		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
 	util.JSONResponse(res, http.StatusOK, "Run shared.", nil)
 }
+
+func CancelRun(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("CancelRun API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunCancel)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	crq, err := modules.CancelRunReqFromJSON(data)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	dequeued, err := crq.CancelRun(req.Context(), user, logger)
+	if err != nil {
+		if isRunAccessError(err) {
+			runAccessError(res, err)
+			return
+		}
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Run cancelled.", map[string]any{
+		"runID":    crq.RunID,
+		"dequeued": dequeued,
+	})
+}
+
+// CloneRun submits a copy of a run with its input patched by the overrides.
+func CloneRun(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("CloneRun API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunCreate)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	crq, err := modules.CloneRunReqFromJSON(data)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	runID, err := crq.CloneRun(req.Context(), user, logger)
+	if err != nil {
+		if modules.IsSpecError(err) {
+			algorithmError(res, err)
+			return
+		}
+		if isRunAccessError(err) {
+			runAccessError(res, err)
+			return
+		}
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Run cloned.", map[string]any{
+		"runID":       runID,
+		"parentRunID": crq.RunID,
+	})
+}
+
+// StreamToken issues a short-lived token for streaming the logs of a run
+// from clients that cannot send the auth cookie, passed as ?token=.
+func StreamToken(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("StreamToken API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	srq, err := modules.StreamTokenReqFromJSON(data)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	token, expiresAt, err := srq.StreamToken(req.Context(), user, logger)
+	if err != nil {
+		runAccessError(res, err)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Stream token", map[string]any{
+		"token":     token,
+		"expiresAt": expiresAt,
+	})
+}
+
+// RunArtifacts lists the files of a run with presigned download URLs.
+func RunArtifacts(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("RunArtifacts API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	runID := req.URL.Query().Get("runId")
+	if runID == "" {
+		util.JSONResponse(res, http.StatusBadRequest, "runId is required", nil)
+		return
+	}
+
+	artifacts, err := modules.RunArtifacts(req.Context(), runID, user, logger)
+	if err != nil {
+		runAccessError(res, err)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Run artifacts", artifacts)
+}
+
+// isRunAccessError reports whether the user cannot access the run.
+func isRunAccessError(err error) bool {
+	return errors.Is(err, modules.ErrRunNotFound) || errors.Is(err, modules.ErrRunAccessDenied) || errors.Is(err, modules.ErrPermissionDenied)
+}
+
+// runAccessError responds with 404 or 403 if the user cannot access the run.
+func runAccessError(res http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, modules.ErrRunNotFound):
+		util.JSONResponse(res, http.StatusNotFound, err.Error(), nil)
+	case errors.Is(err, modules.ErrRunAccessDenied), errors.Is(err, modules.ErrPermissionDenied):
+		util.JSONResponse(res, http.StatusForbidden, err.Error(), nil)
+	default:
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+	}
+}
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"errors"
+	"evolve/modules"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunAccessError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{modules.ErrRunNotFound, http.StatusNotFound},
+		// What ShareRun returns to a user that does not own the run.
+		{fmt.Errorf("%w: only the owner of the run can share it", modules.ErrRunAccessDenied), http.StatusForbidden},
+		{fmt.Errorf("%w: role viewer may not run:read:any", modules.ErrPermissionDenied), http.StatusForbidden},
+		{errors.New("something went wrong"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		runAccessError(res, tt.err)
+		if res.Code != tt.want {
+			t.Errorf("runAccessError(%v) = %d, want %d", tt.err, res.Code, tt.want)
+		}
+	}
+}
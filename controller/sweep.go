@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+)
+
+// CreateSweep expands a base spec and a parameter grid or
+// random sample into a batch of runs linked under a sweep.
+func CreateSweep(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("CreateSweep API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunCreate)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	data, err := util.Body(req)
+	if err != nil {
+		util.JSONResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	sweep, err := modules.SweepReqFromJSON(data)
+	if err != nil {
+		algorithmError(res, err)
+		return
+	}
+
+	sweepID, runIDs, err := sweep.CreateSweep(req.Context(), user.ID, logger)
+	if err != nil {
+		if modules.IsSpecError(err) {
+			algorithmError(res, err)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "Sweep created.", map[string]any{
+		"sweepID": sweepID,
+		"runIDs":  runIDs,
+	})
+}
+
+// UserSweep returns a sweep with the status of all of its runs.
+// The sweep is taken from the {id} path segment.
+func UserSweep(res http.ResponseWriter, req *http.Request) {
+	var logger = util.NewLogger()
+	logger.Info("UserSweep API called.")
+
+	user, ok := authorize(res, req, modules.ActionRunRead)
+	if !ok {
+		return
+	}
+	logger.Info(fmt.Sprintf("User: %s", user))
+
+	sweep, err := modules.UserSweep(req.Context(), req.PathValue("id"), user, logger)
+	if err != nil {
+		if errors.Is(err, modules.ErrSweepNotFound) {
+			util.JSONResponse(res, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		util.JSONResponse(res, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	util.JSONResponse(res, http.StatusOK, "User sweep", sweep)
+}
@@ -0,0 +1,2 @@
+-- Reason a run ended up in its current status (e.g. why it failed).
+ALTER TABLE run ADD COLUMN IF NOT EXISTS statusReason TEXT;
@@ -0,0 +1,24 @@
+-- A sweep is a batch of runs of one algorithm type created
+-- from a base spec and a parameter grid or random sample.
+CREATE TABLE IF NOT EXISTS sweep (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	type TEXT NOT NULL,
+	definition JSONB NOT NULL,
+	createdBy UUID NOT NULL,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sweep_createdBy_idx ON sweep (createdBy);
+
+-- Child runs of a sweep with the parameters that were applied to the base spec.
+CREATE TABLE IF NOT EXISTS sweepRun (
+	sweepID UUID NOT NULL REFERENCES sweep (id) ON DELETE CASCADE,
+	position INT NOT NULL,
+	runID UUID NOT NULL,
+	parameters JSONB NOT NULL,
+	PRIMARY KEY (sweepID, position)
+);
+
+CREATE INDEX IF NOT EXISTS sweepRun_runID_idx ON sweepRun (runID);
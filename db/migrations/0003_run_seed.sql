@@ -0,0 +1,3 @@
+-- Seed of the random number generators of a run, so that it can be reproduced.
+-- Runs created before seeds were configurable have no seed.
+ALTER TABLE run ADD COLUMN IF NOT EXISTS seed INT8;
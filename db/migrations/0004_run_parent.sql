@@ -0,0 +1,4 @@
+-- Run a run was cloned from, if any.
+ALTER TABLE run ADD COLUMN IF NOT EXISTS parentRunID UUID;
+
+CREATE INDEX IF NOT EXISTS run_parentRunID_idx ON run (parentRunID);
@@ -0,0 +1,3 @@
+-- When the run was last started by a runner and when it finished.
+ALTER TABLE run ADD COLUMN IF NOT EXISTS startedAt TIMESTAMPTZ;
+ALTER TABLE run ADD COLUMN IF NOT EXISTS finishedAt TIMESTAMPTZ;
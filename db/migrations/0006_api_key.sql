@@ -0,0 +1,28 @@
+-- Personal API keys let scripts and CI jobs authenticate without the auth
+-- cookie. Only a SHA-256 hash of each key is kept, together with the user
+-- that created it as told by the auth service at that time. Keys do not
+-- keep a role: they act with the role the auth service last returned for
+-- their user, kept in userRole along with when it last validated the user.
+CREATE TABLE IF NOT EXISTS apiKey (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	userID UUID NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	keyHash BYTEA NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[],
+	email TEXT NOT NULL DEFAULT '',
+	userName TEXT NOT NULL DEFAULT '',
+	fullName TEXT NOT NULL DEFAULT '',
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expiresAt TIMESTAMPTZ NOT NULL,
+	lastUsedAt TIMESTAMPTZ,
+	revokedAt TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS apiKey_userID_idx ON apiKey (userID);
+
+CREATE TABLE IF NOT EXISTS userRole (
+	userID UUID PRIMARY KEY,
+	role TEXT NOT NULL,
+	validatedAt TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"evolve/db/connection"
+	"evolve/util"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migrate applies the SQL files in this directory that have not been
+// applied yet, in lexical order. Applied versions are recorded in the
+// schemaMigration table.
+func Migrate(ctx context.Context, logger util.Logger) error {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Migrate: %s", err.Error()))
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schemaMigration (
+			version TEXT PRIMARY KEY,
+			appliedAt TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Migrate.db.Exec: %s", err.Error()))
+		return err
+	}
+
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+
+		var applied bool
+		err := db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schemaMigration WHERE version = $1)", version).Scan(&applied)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Migrate.db.QueryRow: %s", err.Error()))
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		query, err := files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(ctx, string(query)); err != nil {
+			logger.Error(fmt.Sprintf("Migrate.db.Exec(%s): %s", version, err.Error()))
+			return err
+		}
+
+		if _, err := db.Exec(ctx, "INSERT INTO schemaMigration (version) VALUES ($1)", version); err != nil {
+			logger.Error(fmt.Sprintf("Migrate.db.Exec: %s", err.Error()))
+			return err
+		}
+
+		logger.Info(fmt.Sprintf("Applied migration %s", version))
+	}
+
+	return nil
+}
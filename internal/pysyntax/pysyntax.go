@@ -0,0 +1,352 @@
+// Package pysyntax is a small pure-Go Python tokenizer used to check that
+// generated scripts are at least well-formed Python.
+//
+// It follows the rules of the CPython tokenizer for strings, comments,
+// brackets, line continuations and indentation (including inconsistent
+// use of tabs and spaces), checks that every block opener is followed by
+// an indented block and that two values are never written next to each
+// other without an operator (e.g. "(1.0 2.0)"). It does not parse
+// expressions any further.
+package pysyntax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is a syntax error found in a script.
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// indent is an indentation level measured with tabs expanded to the next
+// multiple of 8 (col) and to a single column (alt), like CPython does to
+// detect inconsistent use of tabs and spaces.
+type indent struct {
+	col int
+	alt int
+}
+
+type bracket struct {
+	char rune
+	line int
+}
+
+type checker struct {
+	indents   []indent
+	brackets  []bracket
+	expectInd bool // Previous logical line opened a block.
+
+	// Open triple quoted string.
+	inString    bool
+	stringQuote string
+	stringLine  int
+
+	continued bool // Previous physical line ended with a backslash.
+	lastToken string
+	lastValue bool // Last token was a name, number, string or closing bracket.
+}
+
+// keywords are the Python keywords, including the soft ones,
+// which may be followed by a value.
+var keywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true,
+	"def": true, "del": true, "elif": true, "else": true, "except": true, "finally": true,
+	"for": true, "from": true, "global": true, "if": true, "import": true, "in": true,
+	"is": true, "lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true,
+	"raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+	"match": true, "case": true, "type": true,
+}
+
+// Check tokenizes the source and returns the first syntax error found.
+func Check(source string) error {
+	c := &checker{indents: []indent{{0, 0}}}
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		if err := c.line(i+1, strings.TrimSuffix(line, "\r")); err != nil {
+			return err
+		}
+	}
+
+	end := len(lines)
+	if c.inString {
+		return &Error{Line: c.stringLine, Message: "unterminated triple-quoted string literal"}
+	}
+	if len(c.brackets) > 0 {
+		open := c.brackets[len(c.brackets)-1]
+		return &Error{Line: open.line, Message: fmt.Sprintf("'%c' was never closed", open.char)}
+	}
+	if c.continued {
+		return &Error{Line: end, Message: "unexpected EOF after line continuation"}
+	}
+	if c.expectInd {
+		return &Error{Line: end, Message: "expected an indented block"}
+	}
+	return nil
+}
+
+// line processes one physical line.
+func (c *checker) line(n int, text string) error {
+	pos := 0
+
+	if c.inString {
+		end, ok := findStringEnd(text, 0, c.stringQuote)
+		if !ok {
+			return nil
+		}
+		c.inString = false
+		c.lastToken = "string"
+		pos = end
+	} else if len(c.brackets) == 0 && !c.continued {
+		// Start of a logical line.
+		ind, rest := measureIndent(text)
+		trimmed := strings.TrimSpace(text[rest:])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			return nil
+		}
+		if err := c.indentation(n, ind); err != nil {
+			return err
+		}
+		c.lastToken = ""
+		c.lastValue = false
+		pos = rest
+	}
+	c.continued = false
+
+	for pos < len(text) {
+		ch := rune(text[pos])
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\f':
+			pos++
+		case ch == '#':
+			pos = len(text)
+		case ch == '\\':
+			if strings.TrimSpace(text[pos+1:]) != "" {
+				return &Error{Line: n, Message: "unexpected character after line continuation character"}
+			}
+			c.continued = true
+			pos = len(text)
+		case isStringStart(text, pos):
+			// Adjacent strings are concatenated.
+			if c.lastValue && c.lastToken != "string" {
+				return &Error{Line: n, Message: "invalid syntax, perhaps you forgot a comma?"}
+			}
+			end, err := c.stringLiteral(n, text, pos)
+			if err != nil {
+				return err
+			}
+			c.lastValue = true
+			pos = end
+		case isIdentStart(ch):
+			start := pos
+			for pos < len(text) && isIdentChar(rune(text[pos])) {
+				pos++
+			}
+			name := text[start:pos]
+			if keywords[name] {
+				c.lastValue = name == "True" || name == "False" || name == "None"
+			} else {
+				if c.lastValue {
+					return &Error{Line: n, Message: "invalid syntax, perhaps you forgot a comma?"}
+				}
+				c.lastValue = true
+			}
+			c.lastToken = name
+		case ch >= '0' && ch <= '9' || ch == '.' && pos+1 < len(text) && text[pos+1] >= '0' && text[pos+1] <= '9':
+			if c.lastValue {
+				return &Error{Line: n, Message: "invalid syntax, perhaps you forgot a comma?"}
+			}
+			pos = scanNumber(text, pos)
+			c.lastToken = "number"
+			c.lastValue = true
+		case strings.ContainsRune("([{", ch):
+			c.brackets = append(c.brackets, bracket{char: ch, line: n})
+			c.lastToken = string(ch)
+			c.lastValue = false
+			pos++
+		case strings.ContainsRune(")]}", ch):
+			if len(c.brackets) == 0 {
+				return &Error{Line: n, Message: fmt.Sprintf("unmatched '%c'", ch)}
+			}
+			open := c.brackets[len(c.brackets)-1]
+			if closing(open.char) != ch {
+				return &Error{Line: n, Message: fmt.Sprintf("closing parenthesis '%c' does not match opening parenthesis '%c' on line %d", ch, open.char, open.line)}
+			}
+			c.brackets = c.brackets[:len(c.brackets)-1]
+			c.lastToken = string(ch)
+			c.lastValue = true
+			pos++
+		case strings.ContainsRune("+-*/%@&|^~<>=!:;,.", ch):
+			c.lastToken = string(ch)
+			c.lastValue = false
+			pos++
+		default:
+			return &Error{Line: n, Message: fmt.Sprintf("invalid character '%c'", ch)}
+		}
+	}
+
+	// End of a logical line.
+	if len(c.brackets) == 0 && !c.continued && !c.inString {
+		c.expectInd = c.lastToken == ":"
+	}
+	return nil
+}
+
+// indentation checks the indentation of a logical line against the stack.
+func (c *checker) indentation(n int, ind indent) error {
+	top := c.indents[len(c.indents)-1]
+
+	if c.expectInd {
+		if ind.col <= top.col {
+			return &Error{Line: n, Message: "expected an indented block"}
+		}
+		if ind.alt <= top.alt {
+			return &Error{Line: n, Message: "inconsistent use of tabs and spaces in indentation"}
+		}
+		c.indents = append(c.indents, ind)
+		c.expectInd = false
+		return nil
+	}
+
+	if ind.col > top.col {
+		return &Error{Line: n, Message: "unexpected indent"}
+	}
+
+	for ind.col < c.indents[len(c.indents)-1].col {
+		c.indents = c.indents[:len(c.indents)-1]
+	}
+	top = c.indents[len(c.indents)-1]
+	if ind.col != top.col {
+		return &Error{Line: n, Message: "unindent does not match any outer indentation level"}
+	}
+	if ind.alt != top.alt {
+		return &Error{Line: n, Message: "inconsistent use of tabs and spaces in indentation"}
+	}
+	return nil
+}
+
+// stringLiteral scans a string literal starting at pos and returns the
+// position after it. Triple quoted strings may continue on later lines.
+func (c *checker) stringLiteral(n int, text string, pos int) (int, error) {
+	prefixEnd := pos
+	for text[prefixEnd] != '\'' && text[prefixEnd] != '"' {
+		prefixEnd++
+	}
+	quote := text[prefixEnd : prefixEnd+1]
+	if strings.HasPrefix(text[prefixEnd:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+
+	end, ok := findStringEnd(text, prefixEnd+len(quote), quote)
+	if ok {
+		c.lastToken = "string"
+		return end, nil
+	}
+
+	if len(quote) == 3 {
+		c.inString = true
+		c.stringQuote = quote
+		c.stringLine = n
+		return len(text), nil
+	}
+
+	return 0, &Error{Line: n, Message: "unterminated string literal"}
+}
+
+// findStringEnd returns the position after the closing quote. A backslash
+// always skips the next character, even in raw strings.
+func findStringEnd(text string, pos int, quote string) (int, bool) {
+	for pos < len(text) {
+		if text[pos] == '\\' {
+			pos += 2
+			continue
+		}
+		if strings.HasPrefix(text[pos:], quote) {
+			return pos + len(quote), true
+		}
+		pos++
+	}
+	return 0, false
+}
+
+// measureIndent returns the indentation of a line and where its content starts.
+func measureIndent(text string) (indent, int) {
+	var ind indent
+	pos := 0
+	for ; pos < len(text); pos++ {
+		switch text[pos] {
+		case ' ':
+			ind.col++
+			ind.alt++
+		case '\t':
+			ind.col = (ind.col/8 + 1) * 8
+			ind.alt++
+		case '\f':
+			ind = indent{}
+		default:
+			return ind, pos
+		}
+	}
+	return ind, pos
+}
+
+func isStringStart(text string, pos int) bool {
+	end := pos
+	for end < len(text) && end-pos < 2 && strings.ContainsRune("rRbBuUfF", rune(text[end])) {
+		end++
+	}
+	if end >= len(text) || (text[end] != '\'' && text[end] != '"') {
+		return false
+	}
+
+	prefix := strings.ToLower(text[pos:end])
+	switch prefix {
+	case "", "r", "u", "b", "f", "br", "rb", "fr", "rf":
+	default:
+		return false
+	}
+
+	// The prefix must not be the tail of a longer identifier.
+	return pos == 0 || !isIdentChar(rune(text[pos-1])) || prefix == ""
+}
+
+func scanNumber(text string, pos int) int {
+	for pos < len(text) {
+		ch := text[pos]
+		switch {
+		case ch >= '0' && ch <= '9', ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch == '_', ch == '.':
+			pos++
+		case (ch == '+' || ch == '-') && (text[pos-1] == 'e' || text[pos-1] == 'E'):
+			pos++
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+func isIdentStart(ch rune) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
+
+func isIdentChar(ch rune) bool {
+	return isIdentStart(ch) || ch >= '0' && ch <= '9'
+}
+
+func closing(open rune) rune {
+	switch open {
+	case '(':
+		return ')'
+	case '[':
+		return ']'
+	default:
+		return '}'
+	}
+}
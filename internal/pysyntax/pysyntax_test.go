@@ -0,0 +1,102 @@
+package pysyntax
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{
+			name:   "valid script",
+			source: "import os\n\ndef main():\n\tx = {'a': [1, 2.5e-3]}\n\tif x:\n\t\tprint(f\"{x}\")  # comment\n\treturn x\n\nif __name__ == '__main__':\n\tmain()\n",
+		},
+		{
+			name:   "multi-line brackets and strings",
+			source: "x = (1,\n     2)\ns = \"\"\"a\n  b\"\"\"\ny = r'\\d' + 'it\\'s'\nz = 1 + \\\n    2\n",
+		},
+		{
+			name:    "unclosed bracket",
+			source:  "x = (1, 2\n",
+			wantErr: "line 1: '(' was never closed",
+		},
+		{
+			name:    "mismatched bracket",
+			source:  "x = (1, 2]\n",
+			wantErr: "does not match",
+		},
+		{
+			name:    "unterminated string",
+			source:  "x = 'abc\n",
+			wantErr: "line 1: unterminated string literal",
+		},
+		{
+			name:    "unterminated triple quoted string",
+			source:  "x = '''abc\n",
+			wantErr: "unterminated triple-quoted string literal",
+		},
+		{
+			name:    "missing indented block",
+			source:  "def f():\nreturn 1\n",
+			wantErr: "line 2: expected an indented block",
+		},
+		{
+			name:    "missing block at end of file",
+			source:  "for i in x:\n",
+			wantErr: "expected an indented block",
+		},
+		{
+			name:    "unexpected indent",
+			source:  "x = 1\n\ty = 2\n",
+			wantErr: "line 2: unexpected indent",
+		},
+		{
+			name:    "bad dedent",
+			source:  "if x:\n    y = 1\n  z = 2\n",
+			wantErr: "line 3: unindent does not match any outer indentation level",
+		},
+		{
+			name:    "tabs and spaces",
+			source:  "if x:\n        y = 1\n\tz = 2\n",
+			wantErr: "line 3: inconsistent use of tabs and spaces in indentation",
+		},
+		{
+			name:    "invalid character",
+			source:  "w = (1.0 $ 2.0)\n",
+			wantErr: "invalid character '$'",
+		},
+		{
+			name:    "tuple without separator",
+			source:  "w = (1.000000 2.000000,)\n",
+			wantErr: "line 1: invalid syntax, perhaps you forgot a comma?",
+		},
+		{
+			name:    "names without operator",
+			source:  "x = a b\n",
+			wantErr: "perhaps you forgot a comma?",
+		},
+		{
+			name:   "keywords between values",
+			source: "x = [a for a in b if a is not None and not c] if d else 'e' 'f'\nlambda x: x\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.source)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Check() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Check() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"evolve/controller"
+	"evolve/db/migrations"
+	"evolve/middleware"
+	"evolve/modules"
 	"evolve/modules/sse"
 	"evolve/routes"
+	"evolve/storage"
 	"evolve/util"
 	"fmt"
 	"net"
@@ -42,26 +46,96 @@ func main() {
 	}
 	logger.Info("Redis client initialized successfully.")
 
+	err = storage.Init(*logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize storage: %v. Exiting.", err))
+		os.Exit(1)
+	}
+
+	err = modules.InitPolicy(*logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load authorization policy: %v. Exiting.", err))
+		os.Exit(1)
+	}
+
+	err = util.InitRunQueue(*logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize run queue: %v. Exiting.", err))
+		os.Exit(1)
+	}
+
 	// Use context for cancellation signal propagation.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	err = modules.InitAuthClient(ctx, *logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize auth client: %v. Exiting.", err))
+		os.Exit(1)
+	}
+
+	err = migrations.Migrate(ctx, *logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to apply database migrations: %v. Exiting.", err))
+		os.Exit(1)
+	}
+
+	// Redeliver unacknowledged runs and dead-letter the ones that keep failing.
+	go modules.MonitorRunQueue(ctx, *logger)
+
+	// Record the status changes runners report.
+	go modules.MonitorRunStatus(ctx, *logger)
+
+	// Move the logs of ended runs from Redis to minIO.
+	go modules.MonitorLogArchive(ctx, *logger)
+
 	// Register HTTP Routes
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(routes.TEST, controller.Test)
-	mux.HandleFunc(routes.EA, controller.CreateEA)
-	mux.HandleFunc(routes.GP, controller.CreateGP)
-	mux.HandleFunc(routes.ML, controller.CreateML)
-	mux.HandleFunc(routes.PSO, controller.CreatePSO)
-	mux.HandleFunc(routes.RUNS, controller.UserRuns)
-	mux.HandleFunc(routes.SHARE_RUN, controller.ShareRun)
-	mux.HandleFunc(routes.RUN, controller.UserRun)
+	// Protected routes are only served to authenticated users, whose
+	// handlers find the user in the request context. Requests made with
+	// an API key must also be allowed the scope of the route, if any.
+	public := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, handler)
+	}
+	protected := func(pattern string, scope string, handler http.HandlerFunc) {
+		var h http.Handler = handler
+		if scope != "" {
+			h = middleware.RequireScope(scope, h)
+		}
+		mux.Handle(pattern, middleware.Authenticate(h))
+	}
 
+	public(routes.TEST, controller.Test)
+	protected(routes.ALGORITHM, modules.ScopeRunsWrite, controller.CreateRun)
+	// Methods are required to tell these apart from each other, e.g. for /api/sweeps/preview.
+	protected("POST "+routes.PREVIEW, modules.ScopeRunsRead, controller.PreviewRun)
+	protected("POST "+routes.SWEEPS, modules.ScopeRunsWrite, controller.CreateSweep)
+	protected("GET "+routes.SWEEP, modules.ScopeRunsRead, controller.UserSweep)
+	protected(routes.RUNS, modules.ScopeRunsRead, controller.UserRuns)
+	protected(routes.SHARE_RUN, modules.ScopeRunsWrite, controller.ShareRun)
+	protected(routes.CANCEL_RUN, modules.ScopeRunsWrite, controller.CancelRun)
+	protected(routes.CLONE_RUN, modules.ScopeRunsWrite, controller.CloneRun)
+	protected(routes.RUN, modules.ScopeRunsRead, controller.UserRun)
+	protected(routes.ARTIFACTS, modules.ScopeRunsRead, controller.RunArtifacts)
+	// Signed URLs carry their own token.
+	public("GET "+routes.FILES+"{name...}", controller.File)
+
+	// Run streams also accept a stream token instead of the cookie.
 	sseHandler := sse.GetSSEHandler(*logger)
-	mux.HandleFunc(routes.LOGS, sseHandler)
+	public(routes.LOGS, sseHandler)
+	public(routes.RUN_STATUS, sse.GetStatusSSEHandler(*logger))
+	protected(routes.LOGS_TOKEN, modules.ScopeRunsRead, controller.StreamToken)
+	protected(routes.LOGS_MUX, modules.ScopeRunsRead, sse.GetMultiplexSSEHandler(*logger))
+	protected(routes.LOGS_FILE, modules.ScopeRunsRead, controller.DownloadLogs)
+
+	protected("POST "+routes.API_KEYS, "", controller.CreateAPIKey)
+	protected("GET "+routes.API_KEYS, "", controller.UserAPIKeys)
+	protected("DELETE "+routes.API_KEY, "", controller.RevokeAPIKey)
 	logger.Info(fmt.Sprintf("SSE endpoint registered at %s using Redis Pub/Sub", routes.LOGS))
 
+	logger.Info(fmt.Sprintf("Algorithm types registered at %s: %v", routes.ALGORITHM, modules.AlgorithmTypes()))
+
 	logger.Info(fmt.Sprintf("Test http server on http://localhost%s/api/test", PORT))
 
 	// CORS Configuration.
@@ -112,5 +186,8 @@ func main() {
 	// Close Redis Client.
 	util.ShutDownRedisClient(*logger)
 
+	// Close the connection to the auth service.
+	modules.ShutDownAuthClient(*logger)
+
 	logger.Info("Server exiting.")
 }
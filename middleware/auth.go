@@ -0,0 +1,45 @@
+// Package middleware wraps the handlers of the HTTP routes.
+package middleware
+
+import (
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+)
+
+// Authenticate authenticates the request once with the auth service and
+// passes it on with the user in its context, see modules.UserFromContext.
+// Requests that cannot be authenticated are rejected with a 401.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var logger = util.NewLogger()
+
+		user, err := modules.Auth(req)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Rejected %s %s: %v", req.Method, req.URL.Path, err))
+			util.JSONResponse(res, http.StatusUnauthorized, err.Error(), nil)
+			return
+		}
+
+		next.ServeHTTP(res, req.WithContext(modules.WithUser(req.Context(), user)))
+	})
+}
+
+// RequireScope rejects requests made with an API key that is not allowed
+// the scope with a 403. It must be wrapped by Authenticate.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		user, ok := modules.UserFromContext(req.Context())
+		if !ok {
+			util.JSONResponse(res, http.StatusUnauthorized, "unauthorized", nil)
+			return
+		}
+		if !user.HasScope(scope) {
+			util.JSONResponse(res, http.StatusForbidden, fmt.Sprintf("api key is missing the %s scope", scope), nil)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,159 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"evolve/util"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Algorithm is a family of algorithms that can be submitted as a run.
+// New families are added by implementing it and calling RegisterAlgorithm.
+type Algorithm interface {
+	RunSpec
+	// Decode fills the algorithm from the JSON request body.
+	Decode(jsonData map[string]any) error
+	// Validate checks the algorithm parameters.
+	Validate() error
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]func() Algorithm{}
+)
+
+// RegisterAlgorithm makes an algorithm family available under the run type
+// returned by its RunType method. It panics if the type is already registered.
+func RegisterAlgorithm(newAlgorithm func() Algorithm) {
+	algoType := newAlgorithm().RunType()
+
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	if _, ok := algorithms[algoType]; ok {
+		panic(fmt.Sprintf("algorithm type %s registered twice", algoType))
+	}
+	algorithms[algoType] = newAlgorithm
+}
+
+// AlgorithmTypes returns the registered algorithm types in sorted order.
+func AlgorithmTypes() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	types := make([]string, 0, len(algorithms))
+	for algoType := range algorithms {
+		types = append(types, algoType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// IsAlgorithmType reports whether an algorithm family is registered for the type.
+func IsAlgorithmType(algoType string) bool {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	_, ok := algorithms[algoType]
+	return ok
+}
+
+// AlgorithmFields returns the JSON field names of the spec of the
+// algorithm type in sorted order, or nil if the type is not registered.
+func AlgorithmFields(algoType string) []string {
+	algorithmsMu.RLock()
+	newAlgorithm, ok := algorithms[algoType]
+	algorithmsMu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	fields := jsonFields(reflect.TypeOf(newAlgorithm()))
+	slices.Sort(fields)
+	return fields
+}
+
+// jsonFields returns the names encoding/json uses for the fields
+// of a struct, including those of embedded structs.
+func jsonFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range reflect.VisibleFields(t) {
+		if len(field.Index) > 1 || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// AlgorithmFromJSON decodes the JSON request body
+// into the algorithm registered for the type.
+func AlgorithmFromJSON(algoType string, jsonData map[string]any) (Algorithm, error) {
+	algorithmsMu.RLock()
+	newAlgorithm, ok := algorithms[algoType]
+	algorithmsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm type: %s", algoType)
+	}
+
+	algo := newAlgorithm()
+	if err := algo.Decode(jsonData); err != nil {
+		return nil, err
+	}
+	return algo, nil
+}
+
+// decodeJSON decodes a JSON request body into v.
+// Fields of the wrong type are reported as util.ValidationErrors.
+func decodeJSON(jsonData map[string]any, v any) error {
+	jsonDataBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(jsonDataBytes, v)
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		var errs util.ValidationErrors
+		errs.Add(typeErr.Field, "must be of type %s, got %s", typeErr.Type, typeErr.Value)
+		return errs
+	}
+	return err
+}
+
+// weightsTuple formats fitness weights as a Python tuple, e.g. (1.000000, -1.000000).
+func weightsTuple(weights []float64) string {
+	values := make([]string, len(weights))
+	for i, weight := range weights {
+		values[i] = fmt.Sprintf("%f", weight)
+	}
+	if len(values) == 1 {
+		return fmt.Sprintf("(%s,)", values[0])
+	}
+	return fmt.Sprintf("(%s)", strings.Join(values, ", "))
+}
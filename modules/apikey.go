@@ -0,0 +1,350 @@
+package modules
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"evolve/db/connection"
+	"evolve/util"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scopes an API key can be limited to. A key
+// without scopes may do anything its user may do.
+const (
+	ScopeRunsRead  = "runs:read"
+	ScopeRunsWrite = "runs:write"
+)
+
+// APIKeyPrefix starts every personal API key, which tells
+// them apart from the tokens issued by the auth service.
+const APIKeyPrefix = "evk_"
+
+const (
+	maxAPIKeyNameLength = 100
+	defaultAPIKeyDays   = 30        // How long a key is valid unless expiresInDays is given.
+	maxAPIKeyDays       = 365       // Longest a key may be valid.
+	maxUserRoleRefresh  = time.Hour // Longest the recorded role of a user goes without being renewed.
+	maxRecordedRoles    = 10000     // Users whose recorded role is remembered before old ones are forgotten.
+)
+
+// apiKeyOwnerMaxAge is how long the API keys of a user keep working after
+// the auth service last validated a token of the user. The auth service
+// cannot validate a user without a token, so this is what stops the keys
+// of a user that was deactivated.
+var apiKeyOwnerMaxAge = 7 * 24 * time.Hour
+
+// initAPIKeys reads API_KEY_OWNER_MAX_AGE.
+func initAPIKeys(logger *util.Logger) {
+	if value := os.Getenv("API_KEY_OWNER_MAX_AGE"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			apiKeyOwnerMaxAge = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid API_KEY_OWNER_MAX_AGE %q, using default: %s", value, apiKeyOwnerMaxAge))
+		}
+	}
+}
+
+// ErrAPIKeyNotFound is returned when an API key does not
+// exist, was revoked or belongs to another user.
+var ErrAPIKeyNotFound = errors.New("api key does not exist")
+
+// APIKeyScopes lists the scopes an API key can be limited to.
+func APIKeyScopes() []string {
+	return []string{ScopeRunsRead, ScopeRunsWrite}
+}
+
+type APIKeyReq struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+func APIKeyReqFromJSON(jsonData map[string]any) (*APIKeyReq, error) {
+	k := &APIKeyReq{}
+	if err := decodeJSON(jsonData, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *APIKeyReq) validate() error {
+	var errs util.ValidationErrors
+
+	switch {
+	case k.Name == "":
+		errs.Add("name", "is required")
+	case len(k.Name) > maxAPIKeyNameLength:
+		errs.Add("name", "must not be longer than %d characters", maxAPIKeyNameLength)
+	}
+	for i, scope := range k.Scopes {
+		errs.OneOf(fmt.Sprintf("scopes[%d]", i), scope, APIKeyScopes())
+	}
+	errs.NonNegative("expiresInDays", k.ExpiresInDays)
+	if k.ExpiresInDays > maxAPIKeyDays {
+		errs.Add("expiresInDays", "must not exceed %d, got %d", maxAPIKeyDays, k.ExpiresInDays)
+	}
+	return errs.Err()
+}
+
+// CreateAPIKey creates a personal API key for the user that expires after
+// expiresInDays (30 by default). The key itself is only returned here, the
+// database keeps nothing but its hash.
+func (k *APIKeyReq) CreateAPIKey(ctx context.Context, user *User, logger *util.Logger) (map[string]any, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	days := k.ExpiresInDays
+	if days == 0 {
+		days = defaultAPIKeyDays
+	}
+	scopes := []string{}
+	for _, scope := range k.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Error(fmt.Sprintf("CreateAPIKey.rand.Read: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	prefix := key[:len(APIKeyPrefix)+6]
+	hash := sha256.Sum256([]byte(key))
+
+	// The key acts with the role the user has at the time of each request.
+	if err := recordUserRole(ctx, user, logger); err != nil {
+		return nil, err
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CreateAPIKey: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	var keyID string
+	var createdAt, expiresAt time.Time
+	err = db.QueryRow(ctx, `
+		INSERT INTO apiKey (userID, name, prefix, keyHash, scopes, email, userName, fullName, expiresAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now() + $9::INT * INTERVAL '1 day')
+		RETURNING id, createdAt, expiresAt
+	`, user.ID, k.Name, prefix, hash[:], scopes, user.Email, user.UserName, user.FullName, days).Scan(&keyID, &createdAt, &expiresAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CreateAPIKey.db.QueryRow: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	return map[string]any{
+		"id":        keyID,
+		"name":      k.Name,
+		"key":       key,
+		"prefix":    prefix,
+		"scopes":    scopes,
+		"createdAt": createdAt,
+		"expiresAt": expiresAt,
+	}, nil
+}
+
+// UserAPIKeys lists the API keys of the user that have neither been revoked
+// nor expired, newest first. The keys themselves are not known, only their
+// prefix.
+func UserAPIKeys(ctx context.Context, userID string, logger *util.Logger) ([]map[string]any, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("UserAPIKeys: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, name, prefix, scopes, createdAt, expiresAt, lastUsedAt
+		FROM apiKey
+		WHERE userID = $1 AND revokedAt IS NULL AND expiresAt > now()
+		ORDER BY createdAt DESC
+	`, userID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("UserAPIKeys.db.Query: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+	defer rows.Close()
+
+	keys := []map[string]any{}
+	for rows.Next() {
+		var keyID, name, prefix string
+		var scopes []string
+		var createdAt, expiresAt time.Time
+		var lastUsedAt *time.Time
+		if err := rows.Scan(&keyID, &name, &prefix, &scopes, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			logger.Error(fmt.Sprintf("UserAPIKeys.rows.Scan: %s", err.Error()))
+			return nil, fmt.Errorf("something went wrong")
+		}
+		keys = append(keys, map[string]any{
+			"id":         keyID,
+			"name":       name,
+			"prefix":     prefix,
+			"scopes":     scopes,
+			"createdAt":  createdAt,
+			"expiresAt":  expiresAt,
+			"lastUsedAt": lastUsedAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("UserAPIKeys.rows.Err: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key of the user. Other instances of the
+// service may accept the key until their cached result expires, see
+// AUTH_CACHE_API_KEY_TTL.
+func RevokeAPIKey(ctx context.Context, keyID string, userID string, logger *util.Logger) error {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RevokeAPIKey: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+
+	var hash []byte
+	err = db.QueryRow(ctx, `
+		UPDATE apiKey SET revokedAt = now()
+		WHERE id = $1 AND userID = $2 AND revokedAt IS NULL
+		RETURNING keyHash
+	`, keyID, userID).Scan(&hash)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RevokeAPIKey.db.QueryRow: %s", err.Error()))
+		return ErrAPIKeyNotFound
+	}
+
+	authCache.deleteHash([sha256.Size]byte(hash))
+	logger.Info(fmt.Sprintf("API key %s of user %s revoked", keyID, userID))
+	return nil
+}
+
+// apiKeyUser returns the user an API key was created by, with the role the
+// auth service last returned for the user, or errUnauthorized if the key
+// does not exist, was revoked or has expired, or if no token of the user
+// was validated within apiKeyOwnerMaxAge. Users without a recorded role
+// get the default role of the policy.
+func apiKeyUser(ctx context.Context, key string, logger *util.Logger) (*User, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("apiKeyUser: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	// Creating a key validates its user, so without a recorded
+	// role the key counts from the time it was created.
+	hash := sha256.Sum256([]byte(key))
+	user := &User{}
+	var role *string
+	err = db.QueryRow(ctx, `
+		WITH k AS (
+			UPDATE apiKey SET lastUsedAt = now()
+			WHERE keyHash = $1 AND revokedAt IS NULL AND expiresAt > now()
+				AND COALESCE((SELECT validatedAt FROM userRole WHERE userRole.userID = apiKey.userID), apiKey.createdAt)
+					> now() - $2::INT8 * INTERVAL '1 second'
+			RETURNING id, userID, email, userName, fullName, scopes
+		)
+		SELECT k.id, k.userID, r.role, k.email, k.userName, k.fullName, k.scopes
+		FROM k
+		LEFT JOIN userRole r ON r.userID = k.userID
+	`, hash[:], int64(apiKeyOwnerMaxAge.Seconds())).Scan(&user.APIKeyID, &user.ID, &role, &user.Email, &user.UserName, &user.FullName, &user.Scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("apiKeyUser.db.QueryRow: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	user.Role = policy.DefaultRole
+	if role != nil {
+		user.Role = *role
+	}
+	return user, nil
+}
+
+// recordUserRole stores the role the auth service returned for the user,
+// which is the role the API keys of the user act with, and the time the
+// user was validated.
+func recordUserRole(ctx context.Context, user *User, logger *util.Logger) error {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("recordUserRole: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO userRole (userID, role) VALUES ($1, $2)
+		ON CONFLICT (userID) DO UPDATE SET role = excluded.role, validatedAt = now()
+	`, user.ID, user.Role)
+	if err != nil {
+		logger.Error(fmt.Sprintf("recordUserRole.db.Exec: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+	userRoles.recorded(user)
+	return nil
+}
+
+// roleRecorder remembers the roles this instance recorded, so that
+// validating a token only writes to the database if the role of the user
+// changed, or if the record is about to become too old for the API keys
+// of the user.
+type roleRecorder struct {
+	mu    sync.Mutex
+	roles map[string]recordedRole
+	now   func() time.Time
+}
+
+type recordedRole struct {
+	role string
+	at   time.Time
+}
+
+var userRoles = newRoleRecorder()
+
+func newRoleRecorder() *roleRecorder {
+	return &roleRecorder{roles: map[string]recordedRole{}, now: time.Now}
+}
+
+// refresh is how often the role of a user is recorded even if it did not
+// change, well within apiKeyOwnerMaxAge.
+func (r *roleRecorder) refresh() time.Duration {
+	return min(maxUserRoleRefresh, apiKeyOwnerMaxAge/2)
+}
+
+// stale reports whether the role of the user has to be recorded.
+func (r *roleRecorder) stale(user *User) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recorded, ok := r.roles[user.ID]
+	return !ok || recorded.role != user.Role || r.now().Sub(recorded.at) >= r.refresh()
+}
+
+// recorded remembers that the role of the user was just recorded.
+func (r *roleRecorder) recorded(user *User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if len(r.roles) >= maxRecordedRoles {
+		for userID, recorded := range r.roles {
+			if now.Sub(recorded.at) >= r.refresh() {
+				delete(r.roles, userID)
+			}
+		}
+	}
+	r.roles[user.ID] = recordedRole{role: user.Role, at: now}
+}
@@ -0,0 +1,172 @@
+package modules
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/util"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	logArchiveLockPrefix = "archive:lock:" // Prefix of the keys held while a stream is archived.
+	logArchiveLockTTL    = 5 * time.Minute // Longest an instance may take to archive a stream.
+	logArchiveScanCount  = 100             // How many keys are looked at per SCAN call.
+)
+
+// runIDPattern matches the UUIDs runs are identified by, and thus the
+// keys of the log streams. Other streams (e.g. the run queue) are skipped.
+var runIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// LogArchiveConfig describes how ended log streams are archived.
+type LogArchiveConfig struct {
+	Interval  time.Duration // How often streams are looked for.
+	Retention time.Duration // How long a stream is kept in Redis after archiving it, 0 deletes it right away.
+}
+
+// logArchiveConfig reads the archive configuration from the environment.
+func logArchiveConfig(logger *util.Logger) LogArchiveConfig {
+	config := LogArchiveConfig{
+		Interval:  time.Minute,
+		Retention: time.Hour,
+	}
+
+	if interval := os.Getenv("LOG_ARCHIVE_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil && d > 0 {
+			config.Interval = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid LOG_ARCHIVE_INTERVAL %q, using default: %s", interval, config.Interval))
+		}
+	}
+	if retention := os.Getenv("LOG_ARCHIVE_RETENTION"); retention != "" {
+		if d, err := time.ParseDuration(retention); err == nil && d >= 0 {
+			config.Retention = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid LOG_ARCHIVE_RETENTION %q, using default: %s", retention, config.Retention))
+		}
+	}
+	return config
+}
+
+// MonitorLogArchive moves the log streams of runs that ended (i.e. whose
+// last entry is the EOF marker) to minIO as <runID>/logs.ndjson.gz and
+// then deletes them from Redis or lets them expire. It blocks until ctx
+// is cancelled.
+func MonitorLogArchive(ctx context.Context, logger util.Logger) {
+	config := logArchiveConfig(&logger)
+	logger.Info(fmt.Sprintf("Log archiver started, retention %s.", config.Retention))
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Log archiver stopped.")
+			return
+		case <-ticker.C:
+		}
+
+		if err := archiveEndedLogs(ctx, config, &logger); err != nil && ctx.Err() == nil {
+			logger.Error(fmt.Sprintf("MonitorLogArchive: %v", err))
+		}
+	}
+}
+
+// archiveEndedLogs archives every ended log stream that has not been archived yet.
+func archiveEndedLogs(ctx context.Context, config LogArchiveConfig, logger *util.Logger) error {
+	iter := util.RedisClient.ScanType(ctx, 0, "*-*-*-*-*", logArchiveScanCount, "stream").Iterator()
+	for iter.Next(ctx) {
+		runID := iter.Val()
+		if !runIDPattern.MatchString(runID) {
+			continue
+		}
+
+		// Streams that expire have already been archived.
+		ttl, err := util.RedisClient.TTL(ctx, runID).Result()
+		if err != nil || ttl >= 0 {
+			continue
+		}
+
+		ended, err := logStreamEnded(ctx, runID)
+		if err != nil || !ended {
+			continue
+		}
+
+		if err := archiveRunLogs(ctx, runID, config, logger); err != nil {
+			logger.Error(fmt.Sprintf("MonitorLogArchive: failed to archive logs of run %s: %v", runID, err))
+		}
+	}
+	return iter.Err()
+}
+
+// logStreamEnded reports whether the last entry of the stream is the EOF marker.
+func logStreamEnded(ctx context.Context, runID string) (bool, error) {
+	messages, err := util.RedisClient.XRevRangeN(ctx, runID, "+", "-", 1).Result()
+	if err != nil || len(messages) == 0 {
+		return false, err
+	}
+
+	payload, _ := messages[0].Values[LogDataField].(string)
+	line, ok := LogEntry{Log: json.RawMessage(payload)}.Line()
+	return ok && line.Status == LogEOFStatus, nil
+}
+
+// archiveRunLogs uploads the log stream of the run and removes it from
+// Redis. Only one instance archives a stream at a time.
+func archiveRunLogs(ctx context.Context, runID string, config LogArchiveConfig, logger *util.Logger) error {
+	lock := logArchiveLockPrefix + runID
+	locked, err := util.RedisClient.SetNX(ctx, lock, 1, logArchiveLockTTL).Result()
+	if err != nil || !locked {
+		return err
+	}
+	defer util.RedisClient.Del(context.WithoutCancel(ctx), lock)
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	found, err := eachStreamLog(ctx, runID, func(entry LogEntry) error {
+		// Lines that are not JSON are kept as strings.
+		if !json.Valid(entry.Log) {
+			entry.Log, _ = json.Marshal(string(entry.Log))
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = gz.Write(append(data, '\n'))
+		return err
+	}, logger)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	artifact := runArtifact{fileName: logArchiveName, extension: logArchiveExtension, content: archive.Bytes()}
+	if err := uploadRunArtifact(ctx, runID, artifact, logger); err != nil {
+		return err
+	}
+
+	if config.Retention == 0 {
+		err = util.RedisClient.Del(ctx, runID).Err()
+	} else {
+		err = util.RedisClient.Expire(ctx, runID, config.Retention).Err()
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("MonitorLogArchive: archived %d bytes of logs of run %s", archive.Len(), runID))
+	return nil
+}
@@ -0,0 +1,58 @@
+package modules
+
+import (
+	"context"
+	"evolve/storage"
+	"evolve/util"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultArtifactURLTTL is how long the download URL of an
+// artifact is valid unless ARTIFACT_URL_TTL is set.
+const defaultArtifactURLTTL = 15 * time.Minute
+
+func artifactURLTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("ARTIFACT_URL_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultArtifactURLTTL
+}
+
+// RunArtifacts lists the files of a run the user can read (the code and
+// input of the run and whatever the script wrote, e.g. logbook.txt or
+// graph.png) along with a short-lived URL to download each of them.
+func RunArtifacts(ctx context.Context, runID string, user *User, logger *util.Logger) ([]map[string]any, error) {
+	if _, err := RunAccessMode(ctx, runID, user, logger); err != nil {
+		return nil, err
+	}
+
+	prefix := runID + "/"
+	files, err := storage.List(ctx, prefix)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RunArtifacts.storage.List: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	ttl := artifactURLTTL()
+	expiresAt := time.Now().Add(ttl)
+
+	artifacts := make([]map[string]any, 0, len(files))
+	for _, file := range files {
+		url, err := storage.PresignGet(ctx, prefix+file.Name, ttl)
+		if err != nil {
+			logger.Error(fmt.Sprintf("RunArtifacts.storage.PresignGet: %s", err.Error()))
+			return nil, fmt.Errorf("something went wrong")
+		}
+
+		artifacts = append(artifacts, map[string]any{
+			"name":         file.Name,
+			"size":         file.Size,
+			"lastModified": file.LastModified,
+			"url":          url,
+			"expiresAt":    expiresAt,
+		})
+	}
+	return artifacts, nil
+}
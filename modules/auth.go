@@ -2,60 +2,242 @@ package modules
 
 import (
 	"context"
+	"errors"
 	pb "evolve/proto"
 	"evolve/util"
 	"fmt"
 	"net/http"
 	"os"
-
-	// "os"
+	"slices"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health" // Client side health checking.
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	authTimeout = 5 * time.Second // Timeout of a single call to the auth service.
+
+	// The connection checks the health service of the auth service and
+	// round robins over its healthy addresses. Servers without a health
+	// service are treated as healthy.
+	authServiceConfig = `{
+		"loadBalancingConfig": [{"round_robin": {}}],
+		"healthCheckConfig": {"serviceName": ""}
+	}`
+)
+
+// errUnauthorized is returned by Auth if the request has no valid token.
+var errUnauthorized = errors.New("unauthorized")
+
+var (
+	authConn   *grpc.ClientConn
+	authClient pb.AuthenticateClient
+	authCache  *tokenCache
 )
 
-func Auth(req *http.Request) (map[string]string, error) {
+// InitAuthClient creates the connection to the auth service at
+// AUTH_GRPC_ADDRESS that is shared by all requests. It reconnects on its
+// own whenever the connection is lost.
+func InitAuthClient(ctx context.Context, logger util.Logger) error {
+	address := os.Getenv("AUTH_GRPC_ADDRESS")
+
+	// TODO: Verify the security level of gRPC connection.
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(authServiceConfig),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                30 * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to create gRPC client for %s: %v", address, err))
+		return err
+	}
+
+	authConn = conn
+	authClient = pb.NewAuthenticateClient(conn)
+	authCache = newTokenCache(tokenCacheConfigFromEnv(&logger))
+	initAPIKeys(&logger)
+
+	authConn.Connect()
+	go watchAuthConnection(ctx, logger)
+
+	logger.Info(fmt.Sprintf("Auth client created for %s", address))
+	return nil
+}
+
+// watchAuthConnection logs the state changes of the connection to the
+// auth service and keeps it connected, so that the first request after
+// an idle period does not have to wait for it.
+func watchAuthConnection(ctx context.Context, logger util.Logger) {
+	state := authConn.GetState()
+	for {
+		if state == connectivity.Idle {
+			authConn.Connect()
+		}
+		if !authConn.WaitForStateChange(ctx, state) {
+			return
+		}
+
+		next := authConn.GetState()
+		switch next {
+		case connectivity.TransientFailure:
+			logger.Warn(fmt.Sprintf("Auth service connection %s -> %s, reconnecting.", state, next))
+		case connectivity.Shutdown:
+			return
+		default:
+			logger.Info(fmt.Sprintf("Auth service connection %s -> %s", state, next))
+		}
+		state = next
+	}
+}
+
+// ShutDownAuthClient closes the connection to the auth service.
+func ShutDownAuthClient(logger util.Logger) {
+	logger.Info("Shutting down auth client...")
+	if err := authConn.Close(); err != nil {
+		logger.Error(fmt.Sprintf("Auth client shutdown error: %v", err))
+	} else {
+		logger.Info("Auth client shutdown complete.")
+	}
+}
+
+// User is the user a request was made by, as told by the auth service.
+type User struct {
+	ID       string `json:"id"`
+	Role     string `json:"role"`
+	Email    string `json:"email"`
+	UserName string `json:"userName"`
+	FullName string `json:"fullName"`
+
+	// Set if the request was made with a personal API key,
+	// which may be limited to some scopes.
+	APIKeyID string   `json:"apiKeyID,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+func (u User) String() string {
+	if u.APIKeyID != "" {
+		return fmt.Sprintf("%s (id: %s, role: %s, api key: %s)", u.UserName, u.ID, u.Role, u.APIKeyID)
+	}
+	return fmt.Sprintf("%s (id: %s, role: %s)", u.UserName, u.ID, u.Role)
+}
+
+// HasScope reports whether the user may act within the scope, which is
+// always the case unless the request was made with a limited API key.
+func (u *User) HasScope(scope string) bool {
+	return len(u.Scopes) == 0 || slices.Contains(u.Scopes, scope)
+}
+
+type userContextKey struct{}
+
+// WithUser returns a copy of the context that carries the user.
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the user stored in the context by WithUser,
+// e.g. by middleware.Authenticate for protected routes.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(*User)
+	return user, ok && user != nil
+}
+
+// Auth validates the token of the request and returns the user it belongs
+// to. The token is taken from the Authorization: Bearer header, or else
+// from the t cookie. Personal API keys are looked up in the database, any
+// other token is validated by the auth service. Handlers of protected
+// routes should use UserFromContext instead.
+func Auth(req *http.Request) (*User, error) {
 	var logger = util.NewLogger()
 	logger.Info("Auth called.")
 
-	token, err := req.Cookie("t")
+	token, err := requestToken(req)
 	if err != nil {
 		logger.Error(fmt.Sprintf("No token found in request: %v", err))
-		return nil, fmt.Errorf("unauthorized")
+		return nil, errUnauthorized
 	}
 
-	// TODO: Verify the security level of gRPC connection.
+	if user, valid, ok := authCache.get(token); ok {
+		if !valid {
+			return nil, errUnauthorized
+		}
+		return user, nil
+	}
 
-	// Verify the token via a gRPC call
-	// to the auth micro-service.
-	authConn, err := grpc.NewClient(os.Getenv("AUTH_GRPC_ADDRESS"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	var user *User
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		user, err = apiKeyUser(req.Context(), token, logger)
+	} else {
+		user, err = validateToken(req.Context(), token, logger)
+	}
+	if errors.Is(err, errUnauthorized) {
+		authCache.put(token, nil, false)
+		return nil, err
+	}
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to create gRPC client: %v", err))
-		return nil, fmt.Errorf("something went wrong")
+		return nil, err
+	}
+
+	authCache.put(token, user, true)
+	return user, nil
+}
+
+// requestToken returns the bearer token of the request, or the t cookie
+// if there is no Authorization header.
+func requestToken(req *http.Request) (string, error) {
+	if header := req.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", fmt.Errorf("malformed Authorization header, expected Bearer <token>")
+		}
+		return token, nil
 	}
-	defer authConn.Close()
 
-	authClient := pb.NewAuthenticateClient(authConn)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	cookie, err := req.Cookie("t")
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// validateToken verifies the token via a
+// gRPC call to the auth micro-service.
+func validateToken(ctx context.Context, token string, logger *util.Logger) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
 	defer cancel()
 
-	r, err := authClient.Auth(ctx, &pb.TokenValidateRequest{Token: token.Value})
+	r, err := authClient.Auth(ctx, &pb.TokenValidateRequest{Token: token})
 	if err != nil {
 		logger.Error(fmt.Sprintf("gRPC call failed: %v", err))
 		return nil, fmt.Errorf("something went wrong")
 	}
 
 	if !r.GetValid() {
-		return nil, fmt.Errorf("unauthorized")
+		return nil, errUnauthorized
 	}
 
-	return map[string]string{
-		"id":       r.GetId(),
-		"userName": r.GetUserName(),
-		"fullName": r.GetFullName(),
-		"email":    r.GetEmail(),
-		"role":     r.GetRole(),
-	}, nil
+	user := &User{
+		ID:       r.GetId(),
+		Role:     r.GetRole(),
+		Email:    r.GetEmail(),
+		UserName: r.GetUserName(),
+		FullName: r.GetFullName(),
+	}
 
+	// API keys of the user act with the role it has now and keep working
+	// while it is validated. The token is valid either way, so a failure
+	// is only logged.
+	if userRoles.stale(user) {
+		_ = recordUserRole(ctx, user, logger)
+	}
+	return user, nil
 }
@@ -0,0 +1,80 @@
+package modules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		want   string
+		ok     bool
+	}{
+		{name: "cookie", cookie: "c", want: "c", ok: true},
+		{name: "bearer", header: "Bearer b", want: "b", ok: true},
+		{name: "bearer over cookie", header: "bearer b", cookie: "c", want: "b", ok: true},
+		{name: "api key", header: "Bearer " + APIKeyPrefix + "k", want: APIKeyPrefix + "k", ok: true},
+		{name: "other scheme", header: "Basic dXNlcjpwYXNz", cookie: "c"},
+		{name: "empty bearer", header: "Bearer "},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "t", Value: tt.cookie})
+			}
+
+			got, err := requestToken(req)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("requestToken() = %q, %v, want %q, ok %v", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUserHasScope(t *testing.T) {
+	session := &User{ID: "1"}
+	if !session.HasScope(ScopeRunsWrite) {
+		t.Error("users without an API key must have every scope")
+	}
+
+	readOnly := &User{ID: "1", APIKeyID: "k", Scopes: []string{ScopeRunsRead}}
+	if !readOnly.HasScope(ScopeRunsRead) || readOnly.HasScope(ScopeRunsWrite) {
+		t.Errorf("HasScope of a %v key is wrong", readOnly.Scopes)
+	}
+}
+
+func TestRoleRecorder(t *testing.T) {
+	now := time.Now()
+	r := newRoleRecorder()
+	r.now = func() time.Time { return now }
+
+	user := &User{ID: "u", Role: RoleResearcher}
+	if !r.stale(user) {
+		t.Fatal("a role that was never recorded must be recorded")
+	}
+	r.recorded(user)
+	if r.stale(user) {
+		t.Error("an unchanged role must not be recorded again right away")
+	}
+	if !r.stale(&User{ID: "u", Role: RoleViewer}) {
+		t.Error("a changed role must be recorded")
+	}
+
+	now = now.Add(r.refresh())
+	if !r.stale(user) {
+		t.Error("an unchanged role must be recorded again after the refresh interval")
+	}
+	if r.refresh() >= apiKeyOwnerMaxAge {
+		t.Errorf("refresh interval %s must be shorter than apiKeyOwnerMaxAge %s", r.refresh(), apiKeyOwnerMaxAge)
+	}
+}
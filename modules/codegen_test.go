@@ -0,0 +1,189 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"evolve/internal/pysyntax"
+	"evolve/util"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/codegen/golden")
+
+// codegenFixture holds the test inputs of one algorithm type. Every case
+// is the base input with the case's fields overriding the base ones.
+type codegenFixture struct {
+	Base  map[string]any `json:"base"`
+	Cases []struct {
+		Name     string         `json:"name"`
+		Override map[string]any `json:"override"`
+	} `json:"cases"`
+	Invalid []struct {
+		Name     string         `json:"name"`
+		Override map[string]any `json:"override"`
+		Fields   []string       `json:"fields"` // Fields expected to be reported.
+	} `json:"invalid"`
+}
+
+func loadCodegenFixture(t *testing.T, algoType string) codegenFixture {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "codegen", algoType+".json"))
+	if err != nil {
+		t.Fatalf("every registered algorithm type needs a fixture: %v", err)
+	}
+
+	var fixture codegenFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("invalid fixture for %s: %v", algoType, err)
+	}
+	return fixture
+}
+
+func fixtureInput(base map[string]any, override map[string]any) map[string]any {
+	input := maps.Clone(base)
+	maps.Copy(input, override)
+	return input
+}
+
+func TestCodeGolden(t *testing.T) {
+	for _, algoType := range AlgorithmTypes() {
+		fixture := loadCodegenFixture(t, algoType)
+
+		for _, tc := range fixture.Cases {
+			t.Run(algoType+"/"+tc.Name, func(t *testing.T) {
+				input := fixtureInput(fixture.Base, tc.Override)
+
+				algo, err := AlgorithmFromJSON(algoType, input)
+				if err != nil {
+					t.Fatalf("AlgorithmFromJSON() error = %v", err)
+				}
+				code, err := algo.Code()
+				if err != nil {
+					t.Fatalf("Code() error = %v", err)
+				}
+
+				// Generating twice must give the same script.
+				again, err := algo.Code()
+				if err != nil || again != code {
+					t.Fatalf("Code() is not deterministic")
+				}
+
+				if err := pysyntax.Check(code); err != nil {
+					t.Errorf("generated code is not valid Python: %v", err)
+				}
+
+				golden := filepath.Join("testdata", "codegen", "golden", algoType, tc.Name+".py")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(code), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("missing golden file, run go test ./modules -update: %v", err)
+				}
+				if code != string(want) {
+					t.Errorf("generated code does not match %s, run go test ./modules -update and review the diff", golden)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateReportsAllFields(t *testing.T) {
+	for _, algoType := range AlgorithmTypes() {
+		fixture := loadCodegenFixture(t, algoType)
+
+		for _, tc := range fixture.Invalid {
+			t.Run(algoType+"/"+tc.Name, func(t *testing.T) {
+				input := fixtureInput(fixture.Base, tc.Override)
+
+				algo, err := AlgorithmFromJSON(algoType, input)
+				if err == nil {
+					err = algo.Validate()
+				}
+
+				var errs util.ValidationErrors
+				if !errors.As(err, &errs) {
+					t.Fatalf("expected validation errors, got %v", err)
+				}
+
+				var fields []string
+				for _, fieldErr := range errs {
+					fields = append(fields, fieldErr.Field)
+				}
+				for _, field := range tc.Fields {
+					if !slices.Contains(fields, field) {
+						t.Errorf("expected an error for %s, got %v", field, errs)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSeedGenerated(t *testing.T) {
+	for _, algoType := range AlgorithmTypes() {
+		t.Run(algoType, func(t *testing.T) {
+			input := maps.Clone(loadCodegenFixture(t, algoType).Base)
+			delete(input, "seed")
+
+			algo, err := AlgorithmFromJSON(algoType, input)
+			if err != nil {
+				t.Fatalf("AlgorithmFromJSON() error = %v", err)
+			}
+			seed := algo.RunSeed()
+			if seed < 0 || seed > maxSeed {
+				t.Fatalf("generated seed %d is out of range", seed)
+			}
+
+			code, err := algo.Code()
+			if err != nil {
+				t.Fatalf("Code() error = %v", err)
+			}
+			for _, want := range []string{fmt.Sprintf("random.seed(%d)", seed), fmt.Sprintf("numpy.random.seed(%d)", seed)} {
+				if !strings.Contains(code, want) {
+					t.Errorf("generated code does not contain %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestHofSizeDefault(t *testing.T) {
+	for _, algoType := range []string{"ea", "ml"} {
+		t.Run(algoType, func(t *testing.T) {
+			input := maps.Clone(loadCodegenFixture(t, algoType).Base)
+			delete(input, "hofSize")
+
+			algo, err := AlgorithmFromJSON(algoType, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := algo.Validate(); err != nil {
+				t.Fatalf("expected a spec without hofSize to be valid, got %v", err)
+			}
+
+			code, err := algo.Code()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(code, "HallOfFame(1)") && !strings.Contains(code, "hofSize = 1") {
+				t.Errorf("expected a hall of fame of size 1 in\n%s", code)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package modules
 
 import (
-	"encoding/json"
 	"evolve/util"
 	"fmt"
 	"slices"
@@ -36,24 +35,47 @@ type EA struct {
 	// Differential Evolution Params.
 	CrossOverRate float64 `json:"crossOverRate,omitempty"`
 	ScalingFactor float64 `json:"scalingFactor,omitempty"`
+
+	seeded
+}
+
+func init() {
+	RegisterAlgorithm(func() Algorithm { return &EA{} })
 }
 
 func EAFromJSON(jsonData map[string]any) (*EA, error) {
 	ea := &EA{}
-	jsonDataBytes, err := json.Marshal(jsonData)
-	if err != nil {
+	if err := ea.Decode(jsonData); err != nil {
 		return nil, err
 	}
+	return ea, nil
+}
 
-	if err := json.Unmarshal(jsonDataBytes, ea); err != nil {
-		return nil, err
+func (ea *EA) Decode(jsonData map[string]any) error {
+	if err := decodeJSON(jsonData, ea); err != nil {
+		return err
 	}
-	return ea, nil
+	ea.initSeed()
+	return nil
 }
 
-func (ea *EA) validate() error {
+// benchmarkFunctions are the evaluation functions provided by deap.benchmarks.
+var benchmarkFunctions = []string{"rand", "plane", "sphere", "cigar", "rosenbrock", "h1", "ackley", "bohachevsky", "griewank", "rastrigin", "rastrigin_scaled", "rastrigin_skew", "schaffer", "schwefel", "himmelblau"}
+
+var (
+	eaIndividuals         = []string{"binarystring", "floatingpoint", "integer"}
+	eaEvaluationFunctions = []string{"evalOneMax", "evalProduct", "evalDifference"}
+	eaCrossoverFunctions  = []string{"cxOnePoint", "cxTwoPoint", "cxUniform", "cxPartialyMatched", "cxUniformPartialyMatched", "cxOrdered", "cxMessyOnePoint"}
+	eaMutationFunctions   = []string{"mutFlipBit", "mutShuffleIndexes"}
+	deCrossoverFunctions  = []string{"cxBinomial", "cxExponential"}
+	deMutationFunctions   = []string{"DE/rand/1", "DE/rand/2", "DE/best/1", "DE/best/2", "DE/current-to-best/1", "DE/current-to-rand/1", "DE/rand-to-best/1"}
+)
+
+func (ea *EA) Validate() error {
+	var errs util.ValidationErrors
+
 	if err := util.ValidateAlgorithmName(ea.Algorithm); err != nil {
-		return err
+		errs.Add("algorithm", "%s", err.Error())
 	}
 
 	// If randomrange not given or invalid, set to default.
@@ -63,14 +85,79 @@ func (ea *EA) validate() error {
 		ea.RandomRange = []float64{1, 5}
 	}
 
-	// TODO: Validate remaining fields.
-	return nil
+	errs.OneOf("individual", strings.ToLower(ea.Individual), eaIndividuals)
+	errs.Positive("populationSize", ea.PopulationSize)
+	errs.Positive("generations", ea.Generations)
+	errs.Positive("individualSize", ea.IndividualSize)
+	// hofSize is optional, clients that do not send it keep the best individual.
+	if ea.HofSize == 0 {
+		ea.HofSize = 1
+	}
+	errs.Positive("hofSize", ea.HofSize)
+	errs.Probability("cxpb", ea.Cxpb)
+	errs.Probability("mutpb", ea.Mutpb)
+	errs.Weights("weights", ea.Weights)
+
+	// Built-in evaluation functions are generated,
+	// anything else has to be defined in customEval.
+	errs.PythonIdentifier("evaluationFunction", ea.EvaluationFunction)
+	if util.IsPythonIdentifier(ea.EvaluationFunction) && !slices.Contains(benchmarkFunctions, ea.EvaluationFunction) && !slices.Contains(eaEvaluationFunctions, ea.EvaluationFunction) {
+		if !strings.Contains(ea.CustomEval, fmt.Sprintf("def %s(", ea.EvaluationFunction)) {
+			errs.Add("customEval", "must define the custom evaluation function %s", ea.EvaluationFunction)
+		}
+	}
+
+	errs.Selection(ea.SelectionFunction, ea.TournamentSize)
+	errs.MuLambda(ea.Algorithm, ea.Mu, ea.Lambda, ea.Cxpb, ea.Mutpb)
+
+	if ea.Algorithm == "de" {
+		errs.OneOf("crossoverFunction", ea.CrossoverFunction, deCrossoverFunctions)
+		errs.OneOf("mutationFunction", ea.MutationFunction, deMutationFunctions)
+		errs.Probability("crossOverRate", ea.CrossOverRate)
+		if ea.ScalingFactor < 0 || ea.ScalingFactor > 2 {
+			errs.Add("scalingFactor", "must be within [0, 2], got %v", ea.ScalingFactor)
+		}
+		if ea.Indpb < 0 || ea.Indpb > 2 {
+			errs.Add("indpb", "is used as the DE mutation factor and must be within [0, 2], got %v", ea.Indpb)
+		}
+	} else {
+		errs.OneOf("crossoverFunction", ea.CrossoverFunction, eaCrossoverFunctions)
+		errs.Probability("indpb", ea.Indpb)
+
+		// Anything that is not a plain function name is custom registration code.
+		if util.IsPythonIdentifier(ea.MutationFunction) || ea.MutationFunction == "" {
+			errs.OneOf("mutationFunction", ea.MutationFunction, eaMutationFunctions)
+		}
+	}
+
+	ea.validateSeed(&errs)
+
+	return errs.Err()
+}
+
+func (ea *EA) RunName() string {
+	return fmt.Sprintf("%d-%d", ea.Generations, ea.PopulationSize)
+}
+
+func (ea *EA) RunDescription() string {
+	if ea.Algorithm == "de" {
+		return "Differential Evolution (DE)"
+	}
+	return "Evolutionary Algorithm (EA)"
+}
+
+func (ea *EA) RunType() string {
+	return "ea"
+}
+
+func (ea *EA) RunCommand() string {
+	return "python -m scoop code.py"
 }
 
 func (ea *EA) imports() string {
 	return strings.Join([]string{
 		"import random, os",
-		"from deap import base, creator, tools, algorithms",
+		"from deap import base, creator, tools, algorithms, cma",
 		"import numpy",
 		"import matplotlib.pyplot as plt",
 		"from functools import reduce",
@@ -83,8 +170,7 @@ func (ea *EA) imports() string {
 // If the function is a built-in function, return the corresponding Python code.
 // Otherwise, return the function string as is.
 func (ea *EA) evalFunction() string {
-	if slices.Contains([]string{"rand", "plane", "sphere", "cigar", "rosenbrock", "h1", "ackley", "bohachevsky", "griewank", "rastrigin", "rastrigin_scaled", "rastrigin_skew", "schaffer", "schwefel", "himmelblau"}, ea.EvaluationFunction) {
-		ea.EvaluationFunction = "benchmarks." + ea.EvaluationFunction
+	if slices.Contains(benchmarkFunctions, ea.EvaluationFunction) {
 		return ""
 	}
 
@@ -100,6 +186,14 @@ func (ea *EA) evalFunction() string {
 	}
 }
 
+// evaluator returns the name the evaluation function is registered with.
+func (ea *EA) evaluator() string {
+	if slices.Contains(benchmarkFunctions, ea.EvaluationFunction) {
+		return "benchmarks." + ea.EvaluationFunction
+	}
+	return ea.EvaluationFunction
+}
+
 func (ea *EA) registerIndividual() string {
 	// TODO: Add support for string individual types with initial seed.
 	switch strings.ToLower(ea.Individual) {
@@ -160,7 +254,7 @@ func (ea *EA) callAlgo() string {
 	case "eamucommalambda":
 		return fmt.Sprintf("\tmu = %d\n", ea.Mu) + fmt.Sprintf("\tlambda_ = %d\n", ea.Lambda) + "\tpop, logbook = algorithms.eaMuCommaLambda(pop, toolbox, mu=mu, lambda_=lambda_, cxpb=cxpb, mutpb=mutpb, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
 	case "eagenerateupdate":
-		return fmt.Sprintf("\tstrategy = cma.Strategy(centroid=[5.0]*%d, sigma=5.0, lambda_=20*{N})\n", ea.IndividualSize) + "\ttoolbox.register(\"generate\", strategy.generate, creator.Individual)\n" + "\ttoolbox.register(\"update\", strategy.update)\n" + "\tpop, logbook = algorithms.eaGenerateUpdate(toolbox, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
+		return fmt.Sprintf("\tstrategy = cma.Strategy(centroid=[5.0]*%d, sigma=5.0, lambda_=20*N)\n", ea.IndividualSize) + "\ttoolbox.register(\"generate\", strategy.generate, creator.Individual)\n" + "\ttoolbox.register(\"update\", strategy.update)\n" + "\tpop, logbook = algorithms.eaGenerateUpdate(toolbox, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
 	default:
 		return "\tpop, logbook = algorithms.eaSimple(pop, toolbox, cxpb=cxpb, mutpb=mutpb, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
 	}
@@ -338,7 +432,7 @@ func (ea *EA) deMutationFunction() string {
 }
 
 func (ea *EA) Code() (string, error) {
-	if err := ea.validate(); err != nil {
+	if err := ea.Validate(); err != nil {
 		return "", err
 	}
 
@@ -363,13 +457,13 @@ func (ea *EA) Code() (string, error) {
 	code += ea.CustomSelection + "\n\n"
 
 	code += "toolbox = base.Toolbox()\n\n"
-	weights := strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%f", ea.Weights), "[", "("), "]", ",)")
+	weights := weightsTuple(ea.Weights)
 	code += fmt.Sprintf("creator.create('FitnessMax', base.Fitness, weights=%s)\n", weights)
 	code += "creator.create(\"Individual\", list, fitness=creator.FitnessMax)\n\n"
 
 	code += ea.registerIndividual() + "\n"
 	code += ea.initialGenerator() + "\n"
-	code += fmt.Sprintf("toolbox.register(\"evaluate\", %s)\n", ea.EvaluationFunction)
+	code += fmt.Sprintf("toolbox.register(\"evaluate\", %s)\n", ea.evaluator())
 	code += ea.mutationFunction() + "\n"
 
 	if ea.Algorithm == "de" {
@@ -383,6 +477,7 @@ func (ea *EA) Code() (string, error) {
 	code += "\ntoolbox.register(\"map\", futures.map)\n\n"
 
 	code += "def main():\n"
+	code += ea.seedCode() + "\n"
 	code += fmt.Sprintf("\tpopulationSize = %d\n", ea.PopulationSize)
 	code += fmt.Sprintf("\tgenerations = %d\n", ea.Generations)
 	code += fmt.Sprintf("\tcxpb = %f\n", ea.Cxpb)
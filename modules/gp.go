@@ -1,7 +1,6 @@
 package modules
 
 import (
-	"encoding/json"
 	"evolve/util"
 	"fmt"
 	"strings"
@@ -39,29 +38,147 @@ type GP struct {
 	HofSize            int       `json:"hofSize"`
 	ExprMutMin         int       `json:"expr_mut_min"`
 	ExprMutMax         int       `json:"expr_mut_max"`
+
+	seeded
+}
+
+func init() {
+	RegisterAlgorithm(func() Algorithm { return &GP{} })
 }
 
 func GPFromJSON(jsonData map[string]any) (*GP, error) {
 	gp := &GP{}
-	jsonDataBytes, err := json.Marshal(jsonData)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := json.Unmarshal(jsonDataBytes, gp); err != nil {
+	if err := gp.Decode(jsonData); err != nil {
 		return nil, err
 	}
 	return gp, nil
 }
 
-func (gp *GP) validate() error {
-	if err := util.ValidateAlgorithmName(gp.Algorithm); err != nil {
+func (gp *GP) Decode(jsonData map[string]any) error {
+	if err := decodeJSON(jsonData, gp); err != nil {
 		return err
 	}
-	// TODO: Validate remaining fields.
+	gp.initSeed()
 	return nil
 }
 
+// gpPrimitives are the operators that can be added to the primitive set.
+var gpPrimitives = map[string]struct {
+	code  string
+	arity int
+}{
+	"add": {code: "operator.add", arity: 2},
+	"sub": {code: "operator.sub", arity: 2},
+	"mul": {code: "operator.mul", arity: 2},
+	"div": {code: "protectedDiv", arity: 2},
+	"neg": {code: "operator.neg", arity: 1},
+	"cos": {code: "math.cos", arity: 1},
+	"sin": {code: "math.sin", arity: 1},
+	"lf":  {code: "lf", arity: 1},
+}
+
+var (
+	gpAlgorithms         = []string{"eaSimple", "eaMuPlusLambda", "eaMuCommaLambda", "eaGenerateUpdate"}
+	gpGenerators         = []string{"genFull", "genGrow", "genHalfAndHalf"}
+	gpCrossoverFunctions = []string{"cxOnePoint", "cxOnePointLeafBiased", "cxSemantic"}
+	gpMutationFunctions  = []string{"mutUniform", "mutShrink", "mutNodeReplacement", "mutInsert", "mutEphemeral", "mutSemantic"}
+)
+
+func (gp *GP) Validate() error {
+	var errs util.ValidationErrors
+
+	errs.OneOf("algorithm", gp.Algorithm, gpAlgorithms)
+	errs.Positive("populationSize", gp.PopulationSize)
+	errs.Positive("generations", gp.Generations)
+	errs.Positive("hofSize", gp.HofSize)
+	errs.Probability("cxpb", gp.Cxpb)
+	errs.Probability("mutpb", gp.Mutpb)
+	errs.Weights("weights", gp.Weights)
+	errs.MuLambda(gp.Algorithm, gp.Mu, gp.Lambda, gp.Cxpb, gp.Mutpb)
+	if gp.Algorithm == "eaGenerateUpdate" {
+		errs.Positive("individualSize", gp.IndividualSize)
+	}
+
+	// The evaluation function calls the compiled tree with a single argument.
+	if gp.Arity != 0 && gp.Arity != 1 {
+		errs.Add("arity", "must be 1, got %d", gp.Arity)
+	}
+
+	if len(gp.Operators) == 0 {
+		errs.Add("operators", "must contain at least one operator")
+	}
+	for i, operator := range gp.Operators {
+		if _, ok := gpPrimitives[operator]; !ok {
+			errs.Add(fmt.Sprintf("operators[%d]", i), "unknown operator %q", operator)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, name := range gp.ArgNames {
+		field := fmt.Sprintf("argNames[%d]", i)
+		errs.PythonIdentifier(field, name)
+		if seen[name] {
+			errs.Add(field, "duplicate argument name %q", name)
+		}
+		seen[name] = true
+	}
+
+	errs.Required("realFunction", gp.RealFunction)
+	errs.PythonString("realFunction", gp.RealFunction)
+
+	errs.OneOf("expr", gp.Expr, gpGenerators)
+	errs.NonNegative("min_", gp.Min)
+	if gp.Max < gp.Min {
+		errs.Add("max_", "must be greater than or equal to min_ (%d), got %d", gp.Min, gp.Max)
+	}
+	errs.OneOf("expr_mut", gp.ExprMut, gpGenerators)
+	errs.NonNegative("expr_mut_min", gp.ExprMutMin)
+	if gp.ExprMutMax < gp.ExprMutMin {
+		errs.Add("expr_mut_max", "must be greater than or equal to expr_mut_min (%d), got %d", gp.ExprMutMin, gp.ExprMutMax)
+	}
+
+	errs.OneOf("individualFunction", gp.IndividualFunction, []string{"initIterate"})
+	errs.OneOf("populationFunction", gp.PopulationFunction, []string{"initRepeat"})
+	errs.Selection(gp.SelectionFunction, gp.TournamentSize)
+
+	// An empty crossover or mutation function falls back to the default one.
+	if gp.CrossoverFunction != "" {
+		errs.OneOf("crossoverFunction", gp.CrossoverFunction, gpCrossoverFunctions)
+	}
+	if gp.CrossoverFunction == "cxOnePointLeafBiased" {
+		errs.Probability("terminalProb", gp.TerminalProb)
+	}
+	if gp.MutationFunction != "" {
+		errs.OneOf("mutationFunction", gp.MutationFunction, gpMutationFunctions)
+	}
+	if gp.MutationFunction == "mutEphemeral" {
+		errs.OneOf("mutationMode", gp.MutationMode, []string{"one", "all"})
+	}
+
+	errs.Positive("mateHeight", gp.MateHeight)
+	errs.Positive("mutHeight", gp.MutHeight)
+
+	gp.validateSeed(&errs)
+
+	return errs.Err()
+}
+
+func (gp *GP) RunName() string {
+	return fmt.Sprintf("%d-%d", gp.Generations, gp.PopulationSize)
+}
+
+func (gp *GP) RunDescription() string {
+	return "Genetic Programming (GP)"
+}
+
+func (gp *GP) RunType() string {
+	return "gp"
+}
+
+func (gp *GP) RunCommand() string {
+	return "python -m scoop code.py"
+}
+
 func (gp *GP) imports() string {
 	return strings.Join([]string{
 		"import operator",
@@ -90,43 +207,22 @@ func (gp *GP) evalFunction() string {
 }
 
 func (gp *GP) addPrimitivesToPSET() string {
-	primitiveSource := map[string]map[string]any{
-		"add": {"arity": 2, "code": "operator.add"},
-		"sub": {"arity": 2, "code": "operator.sub"},
-		"mul": {"arity": 2, "code": "operator.mul"},
-		"div": {"arity": 2, "code": "protectedDiv"},
-		"neg": {"arity": 1, "code": "operator.neg"},
-		"cos": {"arity": 1, "code": "math.cos"},
-		"sin": {"arity": 1, "code": "math.sin"},
-		"lf":  {"arity": 1, "code": "lf"},
-	}
-
 	var primitives string
 	for _, operator := range gp.Operators {
-		primitive := primitiveSource[operator]
-		primitives += fmt.Sprintf("pset.addPrimitive(%s, %d)\n", primitive["code"], primitive["arity"])
+		primitive := gpPrimitives[operator]
+		primitives += fmt.Sprintf("pset.addPrimitive(%s, %d)\n", primitive.code, primitive.arity)
 	}
 	return primitives
 }
 
 func (gp *GP) renameArgs() string {
-	argDict := make(map[string]string)
+	var args []string
 	for i, name := range gp.ArgNames {
-		argDict[fmt.Sprintf("ARG%d", i)] = name
+		args = append(args, fmt.Sprintf("'ARG%d': '%s'", i, name))
 	}
 
 	var code string
-
-	var argString string
-	for k, v := range argDict {
-		argString += fmt.Sprintf("'%s': '%s', ", k, v)
-	}
-	// Remove trailing comma and space
-	if len(argString) > 2 {
-		argString = argString[:len(argString)-2]
-	}
-
-	code += fmt.Sprintf("arg_dict = {%s}\n", argString)
+	code += fmt.Sprintf("arg_dict = {%s}\n", strings.Join(args, ", "))
 	code += "pset.renameArguments(**arg_dict)\n\n"
 	return code
 }
@@ -203,7 +299,6 @@ func (gp *GP) callAlgo() string {
 	case "eaMuCommaLambda":
 		code += fmt.Sprintf("\tpop, logbook = algorithms.%s(pop, toolbox, mu=%d, lambda_=%d, cxpb=%v, mutpb=%v, ngen=%d, stats=mstats, halloffame=hof, verbose=True)\n", gp.Algorithm, gp.Mu, gp.Lambda, gp.Cxpb, gp.Mutpb, gp.Generations)
 	case "eaGenerateUpdate":
-		code += "\tnumpy.random.seed(128)\n"
 		code += fmt.Sprintf("\tstrategy = cma.Strategy(centroid=[5.0] * %d, sigma=5.0, lambda_=20 * %d)\n", gp.IndividualSize, gp.IndividualSize)
 		code += "\ttoolbox.register('generate', strategy.generate, creator.Individual)\n"
 		code += "\ttoolbox.register('update', strategy.update)\n"
@@ -247,7 +342,7 @@ func (gp *GP) createPlots() string {
 }
 
 func (gp *GP) Code() (string, error) {
-	if err := gp.validate(); err != nil {
+	if err := gp.Validate(); err != nil {
 		return "", err
 	}
 
@@ -271,7 +366,7 @@ func (gp *GP) Code() (string, error) {
 	code += "pset.addEphemeralConstant('rand101', partial(random.randint, -1, 1))\n"
 	code += gp.renameArgs() + "\n"
 
-	weights := strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%f", gp.Weights), "[", "("), "]", ",)")
+	weights := weightsTuple(gp.Weights)
 	code += fmt.Sprintf("creator.create('Fitness', base.Fitness, weights=%s)\n", weights)
 	code += "creator.create('Individual', gp.PrimitiveTree, fitness=creator.Fitness)\n\n"
 
@@ -291,7 +386,7 @@ func (gp *GP) Code() (string, error) {
 
 	code += "def main():\n"
 	code += "\trootPath = os.path.dirname(os.path.abspath(__file__))\n"
-	code += "\trandom.seed(318)\n"
+	code += gp.seedCode() + "\n"
 	code += fmt.Sprintf("\tpop = toolbox.population(n=%d)\n", gp.PopulationSize)
 	code += fmt.Sprintf("\thof = tools.HallOfFame(%d)\n", gp.HofSize)
 	code += gp.setupStats() + "\n"
@@ -0,0 +1,250 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/storage"
+	"evolve/util"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The format of the log stream of a run, shared by everything reading it.
+const (
+	LogDataField = "log_data" // Stream field holding the JSON encoded LogLine (must match 'runner').
+	LogEOFStatus = "EOF"      // Status of the last entry of a log stream.
+)
+
+// Logs of a run are read from the Redis stream named after the run while
+// it exists, and from the archive in minIO once the stream is gone.
+const (
+	logArchiveName      = "logs"      // Name of the log archive of a run in minIO.
+	logArchiveExtension = "ndjson.gz" // The archive is gzipped NDJSON of LogEntry.
+	logReadCount        = 1000        // How many entries are read per XRANGE call.
+)
+
+// Formats logs can be downloaded in.
+const (
+	LogFormatText   = "text"
+	LogFormatNDJSON = "ndjson"
+	LogFormatGzip   = "gzip"
+)
+
+var ErrLogsNotFound = errors.New("no logs found for this run")
+
+type (
+	// LogEntry is one entry of the log stream of a run.
+	LogEntry struct {
+		ID  string          `json:"id"`  // Redis stream entry ID.
+		Log json.RawMessage `json:"log"` // Log line as sent by the runner.
+	}
+
+	// LogLine is the log line sent by the runner.
+	LogLine struct {
+		Stream string `json:"stream"` // stdout or stderr.
+		Line   string `json:"line"`
+		Status string `json:"status"` // LogEOFStatus for the last entry of a run.
+		RunID  string `json:"runId"`
+	}
+
+	DownloadLogsReq struct {
+		RunID  string
+		Stream string // stdout, stderr or empty for both.
+		Format string
+	}
+)
+
+// Line decodes the log line of the entry.
+func (e LogEntry) Line() (LogLine, bool) {
+	var line LogLine
+	return line, json.Unmarshal(e.Log, &line) == nil
+}
+
+// EachRunLog calls fn for every log entry of the run, in order.
+func EachRunLog(ctx context.Context, runID string, fn func(LogEntry) error, logger *util.Logger) error {
+	found, err := eachStreamLog(ctx, runID, fn, logger)
+	if err != nil || found {
+		return err
+	}
+	return eachArchivedLog(ctx, runID, fn, logger)
+}
+
+// eachStreamLog calls fn for every entry of the log stream of the run
+// and reports whether the stream had any.
+func eachStreamLog(ctx context.Context, runID string, fn func(LogEntry) error, logger *util.Logger) (bool, error) {
+	start := "-"
+	found := false
+	for {
+		messages, err := util.RedisClient.XRangeN(ctx, runID, start, "+", logReadCount).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			logger.Error(fmt.Sprintf("eachStreamLog.XRangeN: %s", err.Error()))
+			return found, fmt.Errorf("something went wrong")
+		}
+
+		for _, msg := range messages {
+			payload, ok := msg.Values[LogDataField].(string)
+			if !ok {
+				continue
+			}
+			if err := fn(LogEntry{ID: msg.ID, Log: json.RawMessage(payload)}); err != nil {
+				return found, err
+			}
+		}
+
+		found = found || len(messages) > 0
+		if len(messages) < logReadCount {
+			return found, nil
+		}
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+// ArchivedRunLogs returns the entries of the log archive of the run,
+// or ErrLogsNotFound if the logs have not been archived.
+func ArchivedRunLogs(ctx context.Context, runID string, logger *util.Logger) ([]LogEntry, error) {
+	var entries []LogEntry
+	err := eachArchivedLog(ctx, runID, func(entry LogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}, logger)
+	return entries, err
+}
+
+// eachArchivedLog calls fn for every entry of the log archive of the run.
+func eachArchivedLog(ctx context.Context, runID string, fn func(LogEntry) error, logger *util.Logger) error {
+	archive, err := storage.Get(ctx, storage.ObjectName(runID, logArchiveName, logArchiveExtension))
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrLogsNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("something went wrong")
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		logger.Error(fmt.Sprintf("eachArchivedLog.gzip.NewReader: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Error(fmt.Sprintf("eachArchivedLog.json.Unmarshal: %s", err.Error()))
+			return fmt.Errorf("something went wrong")
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Error(fmt.Sprintf("eachArchivedLog.scanner.Err: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+	return nil
+}
+
+// Validate checks the stream filter and format, defaulting to text.
+func (d *DownloadLogsReq) Validate() error {
+	if d.RunID == "" {
+		return fmt.Errorf("runId is required")
+	}
+	if d.Stream != "" && d.Stream != "stdout" && d.Stream != "stderr" {
+		return fmt.Errorf("stream must be stdout or stderr")
+	}
+	if d.Format == "" {
+		d.Format = LogFormatText
+	}
+	if !slices.Contains([]string{LogFormatText, LogFormatNDJSON, LogFormatGzip}, d.Format) {
+		return fmt.Errorf("format must be %s, %s or %s", LogFormatText, LogFormatNDJSON, LogFormatGzip)
+	}
+	return nil
+}
+
+// ContentType returns the content type of the download.
+func (d *DownloadLogsReq) ContentType() string {
+	switch d.Format {
+	case LogFormatNDJSON:
+		return "application/x-ndjson"
+	case LogFormatGzip:
+		return "application/gzip"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// FileName returns the name the download is saved as.
+func (d *DownloadLogsReq) FileName() string {
+	name := d.RunID
+	if d.Stream != "" {
+		name += "-" + d.Stream
+	}
+
+	switch d.Format {
+	case LogFormatNDJSON:
+		return name + ".ndjson"
+	case LogFormatGzip:
+		return name + ".ndjson.gz"
+	default:
+		return name + ".log"
+	}
+}
+
+// DownloadLogs writes the logs of the run in the requested format. Text
+// has one line per log line, NDJSON one LogEntry per line and gzip is the
+// gzipped NDJSON. The EOF marker is left out. The user must be able to
+// read the run, which the caller checks.
+func (d *DownloadLogsReq) DownloadLogs(ctx context.Context, w io.Writer, logger *util.Logger) error {
+	out := bufio.NewWriter(w)
+
+	var gz *gzip.Writer
+	if d.Format == LogFormatGzip {
+		gz = gzip.NewWriter(out)
+		w = gz
+	} else {
+		w = out
+	}
+
+	err := EachRunLog(ctx, d.RunID, func(entry LogEntry) error {
+		line, ok := entry.Line()
+		if !ok || line.Status == LogEOFStatus || d.Stream != "" && line.Stream != d.Stream {
+			return nil
+		}
+
+		if d.Format == LogFormatText {
+			text := line.Line
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			_, err := io.WriteString(w, text)
+			return err
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
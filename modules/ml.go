@@ -1,9 +1,9 @@
 package modules
 
 import (
-	"encoding/json"
 	"evolve/util"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -27,34 +27,101 @@ type EAML struct {
 	Mu                       int       `json:"mu,omitempty"`
 	Lambda                   int       `json:"lambda_,omitempty"`
 	HofSize                  int       `json:"hofSize,omitempty"`
+
+	seeded
+}
+
+func init() {
+	RegisterAlgorithm(func() Algorithm { return &EAML{} })
 }
 
 func MLFromJSON(jsonData map[string]any) (*EAML, error) {
 	ml := &EAML{}
-	jsonDataBytes, err := json.Marshal(jsonData)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := json.Unmarshal(jsonDataBytes, ml); err != nil {
+	if err := ml.Decode(jsonData); err != nil {
 		return nil, err
 	}
 	return ml, nil
 }
 
-func (ml *EAML) validate() error {
-	if err := util.ValidateAlgorithmName(ml.Algorithm); err != nil {
+func (ml *EAML) Decode(jsonData map[string]any) error {
+	if err := decodeJSON(jsonData, ml); err != nil {
 		return err
 	}
-	// TODO: Validate remaining fields.
+	ml.initSeed()
 	return nil
 }
 
+var (
+	mlAlgorithms         = []string{"eaSimple", "eaMuPlusLambda", "eaMuCommaLambda", "eaGenerateUpdate"}
+	mlCrossoverFunctions = []string{"cxOnePoint", "cxTwoPoint", "cxMessyOnePoint"}
+	mlMutationFunctions  = []string{"mutFlipBit", "mutShuffleIndexes"}
+)
+
+func (ml *EAML) Validate() error {
+	var errs util.ValidationErrors
+
+	errs.OneOf("algorithm", ml.Algorithm, mlAlgorithms)
+	errs.Positive("populationSize", ml.PopulationSize)
+	errs.Positive("generations", ml.Generations)
+	// hofSize is optional, clients that do not send it keep the best individual.
+	if ml.HofSize == 0 {
+		ml.HofSize = 1
+	}
+	errs.Positive("hofSize", ml.HofSize)
+	errs.Probability("cxpb", ml.Cxpb)
+	errs.Probability("mutpb", ml.Mutpb)
+	errs.Probability("indpb", ml.Indpb)
+	errs.Weights("weights", ml.Weights)
+	errs.MuLambda(ml.Algorithm, ml.Mu, ml.Lambda, ml.Cxpb, ml.Mutpb)
+	if ml.Algorithm == "eaGenerateUpdate" {
+		errs.Positive("lambda_", ml.Lambda)
+	}
+
+	if !strings.Contains(ml.MlEvalFunctionCodeString, "def mlEvalFunction(") {
+		errs.Add("mlEvalFunctionCodeString", "must define mlEvalFunction(individual, X, y)")
+	}
+
+	// The file ID is the second to last path segment of the share link.
+	if u, err := url.Parse(ml.GoogleDriveUrl); err != nil || u.Host != "drive.google.com" || len(strings.Split(ml.GoogleDriveUrl, "/")) < 2 {
+		errs.Add("googleDriveUrl", "must be a Google Drive share link, got %q", ml.GoogleDriveUrl)
+	}
+	errs.PythonString("googleDriveUrl", ml.GoogleDriveUrl)
+
+	errs.Required("sep", ml.Sep)
+	errs.PythonString("sep", ml.Sep)
+	errs.Required("targetColumnName", ml.TargetColumnName)
+	errs.PythonString("targetColumnName", ml.TargetColumnName)
+
+	errs.OneOf("crossoverFunction", ml.CrossoverFunction, mlCrossoverFunctions)
+	errs.OneOf("mutationFunction", ml.MutationFunction, mlMutationFunctions)
+	errs.Selection(ml.SelectionFunction, ml.TournamentSize)
+
+	ml.validateSeed(&errs)
+
+	return errs.Err()
+}
+
+func (ml *EAML) RunName() string {
+	return fmt.Sprintf("%d-%d", ml.Generations, ml.PopulationSize)
+}
+
+func (ml *EAML) RunDescription() string {
+	return "Optimize ML with EA"
+}
+
+func (ml *EAML) RunType() string {
+	return "ml"
+}
+
+func (ml *EAML) RunCommand() string {
+	return "python -m scoop code.py"
+}
+
 func (ml *EAML) imports() string {
 	return strings.Join([]string{
 		"# DEAP imports",
 		"import random, os",
-		"from deap import base, creator, tools, algorithms",
+		"from deap import base, creator, tools, algorithms, cma",
 		"import numpy",
 		"import matplotlib.pyplot as plt",
 		"from functools import reduce",
@@ -98,7 +165,7 @@ func (ml *EAML) callAlgo() string {
 		return fmt.Sprintf("\tmu = %d\n", ml.Mu) + fmt.Sprintf("\tlambda_ = %d\n", ml.Lambda) + "\tpop, logbook = algorithms.eaMuCommaLambda(pop, toolbox, mu=mu, lambda_=lambda_, cxpb=cxpb, mutpb=mutpb, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
 
 	case "eaGenerateUpdate":
-		return "\tnumpy.random.seed(128)\n" + fmt.Sprintf("\tstrategy = cma.Strategy(centroid=[5.0]*len(X.columns), sigma=5.0, lambda_=%d*len(X.columns))\n", ml.Lambda) + "\ttoolbox.register(\"generate\", strategy.generate, creator.Individual)\n" + "\ttoolbox.register(\"update\", strategy.update)\n" + "\tpop, logbook = algorithms.eaGenerateUpdate(toolbox, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
+		return fmt.Sprintf("\tstrategy = cma.Strategy(centroid=[5.0]*len(X.columns), sigma=5.0, lambda_=%d*len(X.columns))\n", ml.Lambda) + "\ttoolbox.register(\"generate\", strategy.generate, creator.Individual)\n" + "\ttoolbox.register(\"update\", strategy.update)\n" + "\tpop, logbook = algorithms.eaGenerateUpdate(toolbox, ngen=generations, stats=stats, halloffame=hof, verbose=True)\n"
 
 	default:
 		return ""
@@ -131,7 +198,7 @@ func (ml *EAML) createPlots() string {
 }
 
 func (ml *EAML) Code() (string, error) {
-	if err := ml.validate(); err != nil {
+	if err := ml.Validate(); err != nil {
 		return "", err
 	}
 
@@ -146,10 +213,11 @@ func (ml *EAML) Code() (string, error) {
 	code += ml.selectionFunction() + "\n"
 	code += "\ntoolbox.register(\"map\", futures.map)\n\n"
 
-	weights := strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%f", ml.Weights), "[", "("), "]", ",)")
+	weights := weightsTuple(ml.Weights)
 	code += strings.Join([]string{
 		"def main():",
 		"\trootPath = os.path.dirname(os.path.abspath(__file__))",
+		ml.seedCode(),
 		fmt.Sprintf("\turl = \"%s\"", ml.GoogleDriveUrl),
 		"\tdf = download_csv_from_google_drive_share_link(url)",
 		fmt.Sprintf("\ttarget = \"%s\"", ml.TargetColumnName),
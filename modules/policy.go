@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"evolve/util"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Roles returned by the auth service.
+const (
+	RoleAdmin      = "admin"
+	RoleResearcher = "researcher"
+	RoleViewer     = "viewer"
+)
+
+// Actions a role may be allowed to take.
+const (
+	ActionRunCreate    = "run:create"     // Create, clone and sweep runs.
+	ActionRunRead      = "run:read"       // Read runs the user has access to, their logs and artifacts.
+	ActionRunCancel    = "run:cancel"     // Cancel runs the user has write access to.
+	ActionRunShare     = "run:share"      // Share runs with other users.
+	ActionRunReadAny   = "run:read:any"   // Read any run and list all runs.
+	ActionRunCancelAny = "run:cancel:any" // Cancel any run.
+	ActionAPIKeyManage = "apikey:manage"  // Create, list and revoke personal API keys.
+
+	actionAll = "*"
+)
+
+// ErrPermissionDenied is returned by Authorize if
+// the role of the user does not allow the action.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Actions lists every action a policy can grant.
+func Actions() []string {
+	return []string{ActionRunCreate, ActionRunRead, ActionRunCancel, ActionRunShare, ActionRunReadAny, ActionRunCancelAny, ActionAPIKeyManage}
+}
+
+// Policy maps each role to the actions it allows. Users with a role
+// that is not in the policy are treated as having the default role.
+type Policy struct {
+	DefaultRole string              `json:"defaultRole"`
+	Roles       map[string][]string `json:"roles"`
+}
+
+// DefaultPolicy lets admins do anything, researchers work with their own
+// runs and viewers only read the runs that were shared with them. Users
+// with a missing or unknown role are viewers, so they get the least access.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		DefaultRole: RoleViewer,
+		Roles: map[string][]string{
+			RoleAdmin:      {actionAll},
+			RoleResearcher: {ActionRunCreate, ActionRunRead, ActionRunCancel, ActionRunShare, ActionAPIKeyManage},
+			RoleViewer:     {ActionRunRead, ActionAPIKeyManage},
+		},
+	}
+}
+
+func (p *Policy) validate() error {
+	var errs util.ValidationErrors
+
+	if _, ok := p.Roles[p.DefaultRole]; !ok {
+		errs.Add("defaultRole", "must be one of the roles, got %q", p.DefaultRole)
+	}
+	for role, actions := range p.Roles {
+		for i, action := range actions {
+			if action != actionAll {
+				errs.OneOf(fmt.Sprintf("roles.%s[%d]", role, i), action, Actions())
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// role returns the role of the policy the user has.
+func (p *Policy) role(user *User) string {
+	if _, ok := p.Roles[user.Role]; ok {
+		return user.Role
+	}
+	return p.DefaultRole
+}
+
+// Allows reports whether the role of the user allows the action.
+func (p *Policy) Allows(user *User, action string) bool {
+	actions := p.Roles[p.role(user)]
+	return slices.Contains(actions, actionAll) || slices.Contains(actions, action)
+}
+
+var policy = DefaultPolicy()
+
+// InitPolicy loads the policy from the JSON file at RBAC_POLICY_FILE,
+// or uses DefaultPolicy if it is not set.
+func InitPolicy(logger util.Logger) error {
+	path := os.Getenv("RBAC_POLICY_FILE")
+	if path == "" {
+		policy = DefaultPolicy()
+		logger.Info("RBAC_POLICY_FILE not set, using the default policy.")
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to read policy file %s: %v", path, err))
+		return err
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(content, p); err != nil {
+		logger.Error(fmt.Sprintf("Failed to parse policy file %s: %v", path, err))
+		return err
+	}
+	if err := p.validate(); err != nil {
+		logger.Error(fmt.Sprintf("Invalid policy file %s: %v", path, err))
+		return err
+	}
+
+	policy = p
+	logger.Info(fmt.Sprintf("Policy loaded from %s with roles %s", path, strings.Join(slices.Sorted(maps.Keys(p.Roles)), ", ")))
+	return nil
+}
+
+// Authorize returns ErrPermissionDenied unless the role
+// of the user allows the action under the policy.
+func Authorize(user *User, action string) error {
+	if !policy.Allows(user, action) {
+		return fmt.Errorf("%w: role %s may not %s", ErrPermissionDenied, policy.role(user), action)
+	}
+	return nil
+}
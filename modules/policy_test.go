@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	if err := p.validate(); err != nil {
+		t.Fatalf("default policy is invalid: %v", err)
+	}
+
+	tests := []struct {
+		role   string
+		action string
+		want   bool
+	}{
+		{RoleAdmin, ActionRunReadAny, true},
+		{RoleAdmin, ActionRunCancelAny, true},
+		{RoleResearcher, ActionRunCreate, true},
+		{RoleResearcher, ActionRunReadAny, false},
+		{RoleViewer, ActionRunRead, true},
+		{RoleViewer, ActionRunCreate, false},
+		{RoleViewer, ActionRunCancel, false},
+		// Missing and unknown roles fall back to viewer.
+		{"", ActionRunRead, true},
+		{"", ActionRunCreate, false},
+		{"intern", ActionRunShare, false},
+		{"intern", ActionRunReadAny, false},
+	}
+	for _, tt := range tests {
+		if got := p.Allows(&User{Role: tt.role}, tt.action); got != tt.want {
+			t.Errorf("Allows(%q, %s) = %v, want %v", tt.role, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	p := &Policy{DefaultRole: "guest", Roles: map[string][]string{RoleViewer: {"run:delete"}}}
+	if err := p.validate(); err == nil {
+		t.Error("unknown default roles and actions must be rejected")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	err := Authorize(&User{Role: RoleViewer}, ActionRunCreate)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Authorize(viewer, %s) = %v, want ErrPermissionDenied", ActionRunCreate, err)
+	}
+	if err := Authorize(&User{Role: RoleAdmin}, ActionRunCancelAny); err != nil {
+		t.Errorf("Authorize(admin, %s) = %v", ActionRunCancelAny, err)
+	}
+}
+
+func TestCanShareRun(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		mode string
+		ok   bool
+	}{
+		{"owner", RoleResearcher, "write", true},
+		{"shared with", RoleResearcher, "read", false},
+		{"viewer shared with", RoleViewer, "read", false},
+		// RunAccessMode lets admins read runs they have no access row for.
+		{"admin", RoleAdmin, "read", true},
+	}
+	for _, tt := range tests {
+		err := canShareRun(&User{ID: "1", Role: tt.role}, tt.mode)
+		if tt.ok && err != nil {
+			t.Errorf("%s: canShareRun() = %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrRunAccessDenied) {
+			t.Errorf("%s: canShareRun() = %v, want ErrRunAccessDenied", tt.name, err)
+		}
+	}
+}
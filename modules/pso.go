@@ -1,9 +1,8 @@
 package modules
 
 import (
-	"encoding/json"
+	"evolve/util"
 	"fmt"
-	"slices"
 	"strings"
 )
 
@@ -20,56 +19,80 @@ type PSO struct {
 	Benchmark      string    `json:"benchmark"` // Evaluation function.
 	PopulationSize int       `json:"populationSize"`
 	Generations    int       `json:"generations"`
+
+	seeded
+}
+
+func init() {
+	RegisterAlgorithm(func() Algorithm { return &PSO{} })
 }
 
 func PSOFromJSON(jsonData map[string]any) (*PSO, error) {
 	pso := &PSO{}
-	jsonDataBytes, err := json.Marshal(jsonData)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := json.Unmarshal(jsonDataBytes, pso); err != nil {
+	if err := pso.Decode(jsonData); err != nil {
 		return nil, err
 	}
 	return pso, nil
 }
 
-func (pso *PSO) validate() error {
-	if !slices.Contains([]string{"original", "multiswarm", "speciation"}, pso.Algorithm) {
-		return fmt.Errorf("invalid PSO algorithm name: %s", pso.Algorithm)
+func (pso *PSO) Decode(jsonData map[string]any) error {
+	if err := decodeJSON(jsonData, pso); err != nil {
+		return err
 	}
+	pso.initSeed()
+	return nil
+}
 
-	if pso.Dimensions <= 0 {
-		return fmt.Errorf("invalid number of dimensions: %d", pso.Dimensions)
-	}
+func (pso *PSO) Validate() error {
+	var errs util.ValidationErrors
+
+	errs.OneOf("algorithm", pso.Algorithm, []string{"original", "multiswarm", "speciation"})
+	errs.Positive("dimensions", pso.Dimensions)
+	errs.Weights("weights", pso.Weights)
 
 	if pso.MinPosition >= pso.MaxPosition {
-		return fmt.Errorf("invalid min/max position: %f/%f", pso.MinPosition, pso.MaxPosition)
+		errs.Add("maxPosition", "must be greater than minPosition, got %f/%f", pso.MinPosition, pso.MaxPosition)
 	}
 
 	if pso.MinSpeed >= pso.MaxSpeed {
-		return fmt.Errorf("invalid min/max speed: %f/%f", pso.MinSpeed, pso.MaxSpeed)
+		errs.Add("maxSpeed", "must be greater than minSpeed, got %f/%f", pso.MinSpeed, pso.MaxSpeed)
 	}
 
-	if !slices.Contains([]string{"rand", "plane", "sphere", "cigar", "rosenbrock", "h1", "ackley", "bohachevsky", "griewank", "rastrigin", "rastrigin_scaled", "rastrigin_skew", "schaffer", "schwefel", "himmelblau"}, pso.Benchmark) {
-		return fmt.Errorf("invalid benchmark function: %s", pso.Benchmark)
+	if pso.Phi1 < 0 {
+		errs.Add("phi1", "must not be negative, got %f", pso.Phi1)
 	}
-
-	if pso.PopulationSize <= 0 {
-		return fmt.Errorf("invalid population size: %d", pso.PopulationSize)
+	if pso.Phi2 < 0 {
+		errs.Add("phi2", "must not be negative, got %f", pso.Phi2)
 	}
 
-	if pso.Generations <= 0 {
-		return fmt.Errorf("invalid number of generations: %d", pso.Generations)
-	}
+	errs.OneOf("benchmark", pso.Benchmark, benchmarkFunctions)
+	errs.Positive("populationSize", pso.PopulationSize)
+	errs.Positive("generations", pso.Generations)
 
-	return nil
+	pso.validateSeed(&errs)
+
+	return errs.Err()
+}
+
+func (pso *PSO) RunName() string {
+	return fmt.Sprintf("%d-%d", pso.Generations, pso.PopulationSize)
+}
+
+func (pso *PSO) RunDescription() string {
+	return "Particle Swarm Optimization"
+}
+
+func (pso *PSO) RunType() string {
+	return "pso"
+}
+
+func (pso *PSO) RunCommand() string {
+	return "python code.py"
 }
 
 func (pso *PSO) imports() string {
 	return strings.Join([]string{
-		"import math, os",
+		"import math, os, random",
 		"import numpy",
 		"from deap import base, benchmarks, creator, tools",
 		"import matplotlib.pyplot as plt",
@@ -190,13 +213,13 @@ func (pso *PSO) thePSOAlgo() string {
 }
 
 func (pso *PSO) Code() (string, error) {
-	if err := pso.validate(); err != nil {
+	if err := pso.Validate(); err != nil {
 		return "", err
 	}
 
 	var code string
 	code += pso.imports() + "\n\n"
-	weights := strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%f", pso.Weights), "[", "("), "]", ",)")
+	weights := weightsTuple(pso.Weights)
 	code += fmt.Sprintf("creator.create('FitnessMax', base.Fitness, weights=%s)\n", weights)
 	code += "creator.create('Particle', numpy.ndarray, fitness=creator.FitnessMax, speed=list, smin=None, smax=None, best=None)\n\n"
 	code += pso.generateAndUpdateParticle() + "\n"
@@ -206,6 +229,7 @@ func (pso *PSO) Code() (string, error) {
 	code += strings.Join([]string{
 		"def main():",
 		"\trootPath = os.path.dirname(os.path.abspath(__file__))",
+		pso.seedCode(),
 		fmt.Sprintf("\tpop = toolbox.population(n=%d)", pso.PopulationSize),
 		"\tstats = tools.Statistics(lambda ind: ind.fitness.values)",
 		"\tstats.register('avg', numpy.mean)",
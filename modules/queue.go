@@ -0,0 +1,45 @@
+package modules
+
+import (
+	"context"
+	"evolve/util"
+	"fmt"
+	"time"
+)
+
+// queueMonitorInterval is how often the run queue is checked
+// for messages that were not acknowledged in time.
+const queueMonitorInterval = 30 * time.Second
+
+// MonitorRunQueue redelivers runs whose runner stopped acknowledging them,
+// marks runs that were moved to the dead-letter stream as failed and trims
+// acknowledged messages from the run stream.
+// It blocks until ctx is cancelled.
+func MonitorRunQueue(ctx context.Context, logger util.Logger) {
+	logger.Info("Run queue monitor started.")
+
+	ticker := time.NewTicker(queueMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Run queue monitor stopped.")
+			return
+		case <-ticker.C:
+		}
+
+		err := util.ReclaimRunMessages(ctx, func(msg util.RunMessage, reason string) {
+			if err := setRunStatus(ctx, msg.RunId, RunStatusFailed, reason, &logger); err != nil {
+				logger.Error(fmt.Sprintf("MonitorRunQueue: failed to mark run %s as failed: %v", msg.RunId, err))
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			logger.Error(fmt.Sprintf("MonitorRunQueue: %v", err))
+		}
+
+		if _, err := util.TrimRunQueue(ctx); err != nil && ctx.Err() == nil {
+			logger.Error(fmt.Sprintf("MonitorRunQueue.TrimRunQueue: %v", err))
+		}
+	}
+}
@@ -3,10 +3,15 @@ package modules
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"evolve/db/connection"
+	"evolve/storage"
 	"evolve/util"
 	"fmt"
+	"slices"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type (
@@ -18,8 +23,157 @@ type (
 	RunDataReq struct {
 		RunID string `json:"runID"`
 	}
+
+	CancelRunReq struct {
+		RunID string `json:"runID"`
+	}
+
+	CloneRunReq struct {
+		RunID     string         `json:"runID"`
+		Overrides map[string]any `json:"overrides"` // JSON merge patch applied to the input of the run.
+	}
 )
 
+var (
+	ErrRunNotFound     = errors.New("run does not exist")
+	ErrRunAccessDenied = errors.New("you do not have access to this run")
+)
+
+// Run statuses. Queued and cancelled are set by this service, the others
+// are reported by runners on the status channel. Progress is only ever
+// sent to clients of the status stream and never stored.
+const (
+	RunStatusQueued    = "queued"
+	RunStatusStarted   = "started"
+	RunStatusProgress  = "progress"
+	RunStatusCompleted = "completed"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+)
+
+// finishedRunStatuses are the statuses after which
+// a run can no longer be cancelled.
+var finishedRunStatuses = []string{RunStatusCompleted, RunStatusFailed, RunStatusCancelled}
+
+// IsRunFinished reports whether a run with the status has finished.
+func IsRunFinished(status string) bool {
+	return slices.Contains(finishedRunStatuses, status)
+}
+
+// setRunStatus updates the status of a run along with the reason for it
+// and the time it started or finished, and sends the transition to the
+// status stream of the run. Finished runs keep their status.
+func setRunStatus(ctx context.Context, runID string, status string, reason string, logger *util.Logger) error {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("setRunStatus: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+
+	var updatedAt time.Time
+	err = db.QueryRow(ctx, `
+		UPDATE run SET status = $1, statusReason = $2, updatedAt = now(),
+			startedAt = CASE WHEN $1::STRING = $4::STRING THEN now() ELSE startedAt END,
+			finishedAt = CASE WHEN $1::STRING = ANY($5) THEN now() ELSE finishedAt END
+		WHERE id = $3 AND NOT status = ANY($5)
+		RETURNING updatedAt
+	`, status, reason, runID, RunStatusStarted, finishedRunStatuses).Scan(&updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(fmt.Sprintf("setRunStatus: run %s has already finished, ignoring status %s", runID, status))
+		return nil
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("setRunStatus.db.QueryRow: %s", err.Error()))
+		return fmt.Errorf("something went wrong")
+	}
+
+	// The database is the source of truth, clients that miss the
+	// event still see the status once they look the run up again.
+	event := util.RunStatusEvent{RunID: runID, Status: status, Reason: reason, Timestamp: updatedAt}
+	if err := util.AppendRunStatusEvent(ctx, event); err != nil {
+		logger.Error(fmt.Sprintf("setRunStatus: failed to send status %s of run %s: %v", status, runID, err))
+	}
+	return nil
+}
+
+// RunAccessMode returns the access mode (read or write) the user has on
+// the run, ErrRunNotFound if the run does not exist or ErrRunAccessDenied
+// if it has not been shared with the user. Users whose role allows
+// ActionRunReadAny can read every run.
+func RunAccessMode(ctx context.Context, runID string, user *User, logger *util.Logger) (string, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RunAccessMode: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	var exists bool
+	var mode string
+	err = db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM run WHERE id = $1),
+			COALESCE((SELECT mode FROM access WHERE runID = $1 AND userID = $2 LIMIT 1), '')
+	`, runID, user.ID).Scan(&exists, &mode)
+	if err != nil {
+		// Malformed run IDs end up here too.
+		logger.Error(fmt.Sprintf("RunAccessMode.db.QueryRow: %s", err.Error()))
+		return "", ErrRunNotFound
+	}
+
+	switch {
+	case !exists:
+		return "", ErrRunNotFound
+	case mode == "" && Authorize(user, ActionRunReadAny) == nil:
+		return "read", nil
+	case mode == "":
+		return "", ErrRunAccessDenied
+	default:
+		return mode, nil
+	}
+}
+
+// ActiveUserRuns returns the IDs of at most limit unfinished runs
+// the user has access to, most recently created first.
+func ActiveUserRuns(ctx context.Context, userID string, limit int, logger *util.Logger) ([]string, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("ActiveUserRuns: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT r.id
+		FROM run r
+		WHERE NOT r.status = ANY($2)
+			AND EXISTS (SELECT 1 FROM access a WHERE a.runID = r.id AND a.userID = $1)
+		ORDER BY r.createdAt DESC
+		LIMIT $3
+	`, userID, finishedRunStatuses, limit)
+	if err != nil {
+		logger.Error(fmt.Sprintf("ActiveUserRuns.db.Query: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+	defer rows.Close()
+
+	runIDs := []string{}
+	for rows.Next() {
+		var runID string
+		if err := rows.Scan(&runID); err != nil {
+			logger.Error(fmt.Sprintf("ActiveUserRuns.rows.Scan: %s", err.Error()))
+			return nil, fmt.Errorf("something went wrong")
+		}
+		runIDs = append(runIDs, runID)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("ActiveUserRuns.rows.Err: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	return runIDs, nil
+}
+
+// runColumns are the columns of the runs listed by UserRuns and AllRuns.
+const runColumns = "id, name, description, status, type, command, createdBy, createdAt, updatedAt"
+
 func UserRuns(ctx context.Context, userID string, logger *util.Logger) ([]map[string]string, error) {
 	db, err := connection.PoolConn(ctx)
 	if err != nil {
@@ -50,12 +204,43 @@ func UserRuns(ctx context.Context, userID string, logger *util.Logger) ([]map[st
 
 	// logger.Info(fmt.Sprintf("RunIDs: %s", runIDs))
 
-	rows, err = db.Query(ctx, "SELECT * FROM run WHERE id = ANY($1)", runIDs)
+	rows, err = db.Query(ctx, "SELECT "+runColumns+" FROM run WHERE id = ANY($1)", runIDs)
 	if err != nil {
 		logger.Error(fmt.Sprintf("UserRuns.db.Query: %s", err.Error()))
 		return nil, fmt.Errorf("something went wrong")
 	}
 
+	// logger.Info(fmt.Sprintf("Runs: %s", runs))
+
+	return scanRuns(rows, userID, logger)
+}
+
+// AllRuns lists every run of every user, newest first, for users whose
+// role allows ActionRunReadAny. Runs of other users are listed as shared.
+func AllRuns(ctx context.Context, user *User, logger *util.Logger) ([]map[string]string, error) {
+	if err := Authorize(user, ActionRunReadAny); err != nil {
+		return nil, err
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("AllRuns: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	rows, err := db.Query(ctx, "SELECT "+runColumns+" FROM run ORDER BY createdAt DESC")
+	if err != nil {
+		logger.Error(fmt.Sprintf("AllRuns.db.Query: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	return scanRuns(rows, user.ID, logger)
+}
+
+// scanRuns reads runs selected with runColumns as seen by the user.
+func scanRuns(rows pgx.Rows, userID string, logger *util.Logger) ([]map[string]string, error) {
+	defer rows.Close()
+
 	runs := []map[string]string{}
 	for rows.Next() {
 		var id string
@@ -70,7 +255,7 @@ func UserRuns(ctx context.Context, userID string, logger *util.Logger) ([]map[st
 
 		err := rows.Scan(&id, &name, &description, &status, &runType, &command, &createdBy, &createdAt, &updatedAt)
 		if err != nil {
-			logger.Error(fmt.Sprintf("UserRuns.rows.Scan: %s", err.Error()))
+			logger.Error(fmt.Sprintf("scanRuns.rows.Scan: %s", err.Error()))
 			return nil, fmt.Errorf("something went wrong")
 		}
 
@@ -95,8 +280,10 @@ func UserRuns(ctx context.Context, userID string, logger *util.Logger) ([]map[st
 
 		runs = append(runs, run)
 	}
-
-	// logger.Info(fmt.Sprintf("Runs: %s", runs))
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("scanRuns.rows.Err: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
 
 	return runs, nil
 }
@@ -114,20 +301,23 @@ func ShareRunReqFromJSON(jsonData map[string]any) (*ShareRunReq, error) {
 	return s, nil
 }
 
-func (s *ShareRunReq) ShareRun(ctx context.Context, logger *util.Logger) error {
+// ShareRun gives the users read access to a run the user owns.
+func (s *ShareRunReq) ShareRun(ctx context.Context, user *User, logger *util.Logger) error {
+	// Check if user may share the run.
+	mode, err := RunAccessMode(ctx, s.RunID, user, logger)
+	if err != nil {
+		return err
+	}
+	if err := canShareRun(user, mode); err != nil {
+		return err
+	}
+
 	db, err := connection.PoolConn(ctx)
 	if err != nil {
 		logger.Error(fmt.Sprintf("ShareRun: %s", err.Error()))
 		return fmt.Errorf("something went wrong")
 	}
 
-	// Check if run exists.
-	var runID string
-	if err := db.QueryRow(ctx, "SELECT id FROM run WHERE id = $1", s.RunID).Scan(&runID); err != nil {
-		logger.Error(fmt.Sprintf("ShareRun.db.QueryRow: %s", err.Error()))
-		return fmt.Errorf("run does not exist")
-	}
-
 	// Check if provided emails exist.
 	rows, err := db.Query(ctx, "SELECT id FROM users WHERE email = ANY($1)", s.UserEmailList)
 	if err != nil {
@@ -162,6 +352,16 @@ func (s *ShareRunReq) ShareRun(ctx context.Context, logger *util.Logger) error {
 	return nil
 }
 
+// canShareRun checks that a user with the access mode on a run may share
+// it: only users with write access, or whose role allows ActionRunReadAny,
+// may. Users the run was shared with cannot share it further.
+func canShareRun(user *User, mode string) error {
+	if mode == "write" || Authorize(user, ActionRunReadAny) == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: only the owner of the run can share it", ErrRunAccessDenied)
+}
+
 func RunDataReqFromJSON(jsonData map[string]any) (*RunDataReq, error) {
 	r := &RunDataReq{}
 	jsonDataBytes, err := json.Marshal(jsonData)
@@ -175,38 +375,193 @@ func RunDataReqFromJSON(jsonData map[string]any) (*RunDataReq, error) {
 	return r, nil
 }
 
-func (r *RunDataReq) UserRun(ctx context.Context, userID string, logger *util.Logger) (map[string]string, error) {
+func (r *RunDataReq) UserRun(ctx context.Context, user *User, logger *util.Logger) (map[string]string, error) {
+	// Check if user has access to the run.
+	if _, err := RunAccessMode(ctx, r.RunID, user, logger); err != nil {
+		return nil, err
+	}
+
 	db, err := connection.PoolConn(ctx)
 	if err != nil {
 		logger.Error(fmt.Sprintf("RunData: %s", err.Error()))
 		return nil, fmt.Errorf("something went wrong")
 	}
 
-	// Check if user has access to the run.
-	var runID string
-	if err := db.QueryRow(ctx, "SELECT runID FROM access WHERE userID = $1 AND runID = $2", userID, r.RunID).Scan(&runID); err != nil {
-		logger.Error(fmt.Sprintf("RunData.db.QueryRow: %s", err.Error()))
-		return nil, fmt.Errorf("run does not exist")
-	}
-
-	var id, name, description, status, runType, command, createdBy string
+	var id, name, description, status, statusReason, runType, command, seed, parentRunID, createdBy string
 	var createdAt, updatedAt time.Time
-	// Get the run details like name, description, status, type, command, createdBy, createdAt, updatedAt.
-	err = db.QueryRow(ctx, "SELECT id, name, description, status, type, command, createdBy, createdAt, updatedAt FROM run WHERE id = $1", r.RunID).Scan(&id, &name, &description, &status, &runType, &command, &createdBy, &createdAt, &updatedAt)
+	var startedAt, finishedAt *time.Time
+	// Get the run details like name, description, status, type, command, seed, parentRunID, createdBy, createdAt, updatedAt, startedAt, finishedAt.
+	err = db.QueryRow(ctx, "SELECT id, name, description, status, COALESCE(statusReason, ''), type, command, COALESCE(seed::STRING, ''), COALESCE(parentRunID::STRING, ''), createdBy, createdAt, updatedAt, startedAt, finishedAt FROM run WHERE id = $1", r.RunID).Scan(&id, &name, &description, &status, &statusReason, &runType, &command, &seed, &parentRunID, &createdBy, &createdAt, &updatedAt, &startedAt, &finishedAt)
 	if err != nil {
 		logger.Error(fmt.Sprintf("RunData.db.QueryRow: %s", err.Error()))
 		return nil, fmt.Errorf("something went wrong")
 	}
 
-	return map[string]string{
-		"id":          id,
-		"name":        name,
-		"description": description,
-		"status":      status,
-		"type":        runType,
-		"command":     command,
-		"createdBy":   createdBy,
-		"createdAt":   createdAt.Local().String(),
-		"updatedAt":   updatedAt.Local().String(),
-	}, nil
+	run := map[string]string{
+		"id":           id,
+		"name":         name,
+		"description":  description,
+		"status":       status,
+		"statusReason": statusReason,
+		"type":         runType,
+		"command":      command,
+		"seed":         seed,
+		"parentRunID":  parentRunID,
+		"createdBy":    createdBy,
+		"createdAt":    createdAt.Local().String(),
+		"updatedAt":    updatedAt.Local().String(),
+		"startedAt":    "",
+		"finishedAt":   "",
+	}
+	if startedAt != nil {
+		run["startedAt"] = startedAt.Local().String()
+	}
+	if finishedAt != nil {
+		run["finishedAt"] = finishedAt.Local().String()
+	}
+	return run, nil
+}
+
+func CancelRunReqFromJSON(jsonData map[string]any) (*CancelRunReq, error) {
+	c := &CancelRunReq{}
+	jsonDataBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(jsonDataBytes, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CancelRun stops a run. If the run is still queued it is removed from
+// the queue, otherwise a cancel signal is published for the runner.
+// It returns true if the run was removed from the queue. Users whose
+// role allows ActionRunCancelAny can cancel runs they cannot write.
+func (c *CancelRunReq) CancelRun(ctx context.Context, user *User, logger *util.Logger) (bool, error) {
+	// Check if user has write access to the run.
+	mode, err := RunAccessMode(ctx, c.RunID, user, logger)
+	if err != nil {
+		return false, err
+	}
+
+	reason := "cancelled by user"
+	if mode != "write" {
+		if Authorize(user, ActionRunCancelAny) != nil {
+			return false, fmt.Errorf("%w: you do not have permission to cancel this run", ErrRunAccessDenied)
+		}
+		reason = fmt.Sprintf("cancelled by %s %s", user.Role, user.UserName)
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CancelRun: %s", err.Error()))
+		return false, fmt.Errorf("something went wrong")
+	}
+
+	var status string
+	if err := db.QueryRow(ctx, "SELECT status FROM run WHERE id = $1", c.RunID).Scan(&status); err != nil {
+		logger.Error(fmt.Sprintf("CancelRun.db.QueryRow: %s", err.Error()))
+		return false, fmt.Errorf("something went wrong")
+	}
+
+	if IsRunFinished(status) {
+		return false, fmt.Errorf("run has already finished with status %s", status)
+	}
+
+	dequeued, err := util.DequeueRunRequest(ctx, c.RunID)
+	if err != nil {
+		return false, fmt.Errorf("something went wrong")
+	}
+
+	// Run has already been picked up by a runner.
+	if !dequeued {
+		if err := util.PublishRunCancel(ctx, c.RunID); err != nil {
+			return false, fmt.Errorf("something went wrong")
+		}
+	}
+
+	if err := setRunStatus(ctx, c.RunID, RunStatusCancelled, reason, logger); err != nil {
+		return false, err
+	}
+
+	return dequeued, nil
+}
+
+func CloneRunReqFromJSON(jsonData map[string]any) (*CloneRunReq, error) {
+	c := &CloneRunReq{}
+	jsonDataBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(jsonDataBytes, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// clonedRunSpec records the run a spec was cloned from.
+type clonedRunSpec struct {
+	Algorithm
+	parentRunID string
+}
+
+func (c clonedRunSpec) ParentRunID() string {
+	return c.parentRunID
+}
+
+// MarshalJSON stores the algorithm spec as the input of the run.
+func (c clonedRunSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Algorithm)
+}
+
+// CloneRun submits a new run with the stored input of a run the user can
+// read, after applying the overrides to it. The seed of the run is kept
+// unless it is overridden, or removed with "seed": null to generate a new one.
+func (c *CloneRunReq) CloneRun(ctx context.Context, user *User, logger *util.Logger) (string, error) {
+	// Check if user has access to the run.
+	if _, err := RunAccessMode(ctx, c.RunID, user, logger); err != nil {
+		return "", err
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CloneRun: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	var runType string
+	if err := db.QueryRow(ctx, "SELECT type FROM run WHERE id = $1", c.RunID).Scan(&runType); err != nil {
+		logger.Error(fmt.Sprintf("CloneRun.db.QueryRow: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	if !IsAlgorithmType(runType) {
+		return "", fmt.Errorf("runs of type %s cannot be cloned", runType)
+	}
+
+	content, err := storage.Get(ctx, storage.ObjectName(c.RunID, "input", "json"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", fmt.Errorf("the input of the run is not available")
+		}
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	var input map[string]any
+	if err := json.Unmarshal(content, &input); err != nil {
+		logger.Error(fmt.Sprintf("CloneRun.json.Unmarshal: %s", err.Error()))
+		return "", fmt.Errorf("the input of the run is not valid JSON")
+	}
+
+	patched, _ := util.MergePatch(input, c.Overrides).(map[string]any)
+
+	algo, err := AlgorithmFromJSON(runType, patched)
+	if err != nil {
+		return "", &SpecError{Err: err}
+	}
+
+	return SubmitRun(ctx, clonedRunSpec{Algorithm: algo, parentRunID: c.RunID}, user.ID, logger)
 }
@@ -0,0 +1,46 @@
+package modules
+
+import (
+	"evolve/util"
+	"fmt"
+	"math/rand/v2"
+)
+
+// maxSeed is the largest seed accepted by numpy.random.seed.
+const maxSeed = 1<<32 - 1
+
+// seeded is embedded in every algorithm spec. The seed is used for both
+// random and numpy.random, so a run can be reproduced from its spec.
+type seeded struct {
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+// initSeed generates a seed if the request did not contain one.
+func (s *seeded) initSeed() {
+	if s.Seed == nil {
+		seed := rand.Int64N(maxSeed + 1)
+		s.Seed = &seed
+	}
+}
+
+// validateSeed reports a missing seed or one numpy does not accept.
+func (s *seeded) validateSeed(errs *util.ValidationErrors) {
+	if s.Seed == nil {
+		errs.Add("seed", "is required")
+	} else if *s.Seed < 0 || *s.Seed > maxSeed {
+		errs.Add("seed", "must be within [0, %d], got %d", maxSeed, *s.Seed)
+	}
+}
+
+// RunSeed returns the seed stored with the run.
+func (s *seeded) RunSeed() int64 {
+	if s.Seed == nil {
+		return 0
+	}
+	return *s.Seed
+}
+
+// seedCode seeds both random number generators at the start of main().
+func (s *seeded) seedCode() string {
+	return fmt.Sprintf("\trandom.seed(%d)\n\tnumpy.random.seed(%d)", s.RunSeed(), s.RunSeed())
+}
@@ -0,0 +1,132 @@
+package sse
+
+import (
+	"encoding/json"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventWriter writes the log lines of one XREAD batch and flushes them
+// together, either as one event per line or as a single event whose data
+// is a JSON array of all lines (?batch=array). Logbook rows among the
+// lines are also sent as progress events.
+type eventWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	array   bool
+	limiter *lineLimiter
+	logbook *logbookParser
+}
+
+// write writes an event without flushing it. The id is the Redis stream
+// entry ID, which clients send back as Last-Event-ID when they reconnect.
+func (e *eventWriter) write(id string, event string, data string) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+
+	_, err := e.w.Write([]byte(b.String()))
+	return err
+}
+
+// done sends the event marking the end of the stream.
+func (e *eventWriter) done() error {
+	if err := e.write("", sseDoneEvent, `{"message": "Stream ended."}`); err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// batch sends the messages of one XREAD call with a single flush.
+// It returns the ID of the last message and whether it was the EOF marker,
+// in which case the done event has been sent too.
+func (e *eventWriter) batch(messages []redis.XMessage, logger *util.Logger) (string, bool, error) {
+	var lastID string
+	var lines []json.RawMessage
+	var progress []map[string]any
+	ended := false
+
+	for _, msg := range messages {
+		// Messages that are skipped still move the position forward.
+		lastID = msg.ID
+
+		logPayloadStr, ok := msg.Values[modules.LogDataField].(string)
+		if !ok {
+			logger.Warn(fmt.Sprintf("[SSE Stream Handler] Invalid data format in message ID '%s': Missing or non-string field '%s'", msg.ID, modules.LogDataField))
+			continue
+		}
+
+		records := e.logbook.payload(logPayloadStr)
+		if e.array {
+			lines = append(lines, jsonPayload(logPayloadStr))
+			progress = append(progress, records...)
+		} else {
+			if err := e.write(msg.ID, "", logPayloadStr); err != nil {
+				return "", false, err
+			}
+			if err := e.progress(records); err != nil {
+				return "", false, err
+			}
+		}
+
+		// Check if this message is the EOF marker.
+		var logData modules.LogLine
+		if json.Unmarshal([]byte(logPayloadStr), &logData) == nil && logData.Status == modules.LogEOFStatus {
+			ended = true
+			break
+		}
+	}
+
+	if len(lines) > 0 {
+		data, err := json.Marshal(lines)
+		if err != nil {
+			return "", false, err
+		}
+		if err := e.write(lastID, "", string(data)); err != nil {
+			return "", false, err
+		}
+	}
+	if err := e.progress(progress); err != nil {
+		return "", false, err
+	}
+
+	if ended {
+		return lastID, true, e.done()
+	}
+	return lastID, false, e.rc.Flush()
+}
+
+// progress sends logbook records as progress events. They carry no id,
+// so that a reconnecting client resumes after the line they were read from.
+func (e *eventWriter) progress(records []map[string]any) error {
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := e.write("", sseProgressEvent, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonPayload returns the payload as JSON, quoting it if the
+// runner did not send valid JSON, so that the array stays valid.
+func jsonPayload(payload string) json.RawMessage {
+	if json.Valid([]byte(payload)) {
+		return json.RawMessage(payload)
+	}
+	quoted, _ := json.Marshal(payload)
+	return quoted
+}
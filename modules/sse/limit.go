@@ -0,0 +1,60 @@
+package sse
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+)
+
+// linesPerSecond returns the maximum number of log lines sent per second
+// to a client, read from SSE_LINES_PER_SECOND. 0 means unlimited.
+func linesPerSecond() int {
+	rate, err := strconv.Atoi(os.Getenv("SSE_LINES_PER_SECOND"))
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// lineLimiter spaces out batches so that on average no more than
+// rate lines are sent per second.
+type lineLimiter struct {
+	rate int
+	next time.Time // Earliest time the next batch may be sent.
+}
+
+func newLineLimiter(rate int) *lineLimiter {
+	return &lineLimiter{rate: rate}
+}
+
+// batchSize limits a batch to one second worth of lines.
+func (l *lineLimiter) batchSize(count int64) int64 {
+	if l.rate > 0 && int64(l.rate) < count {
+		return int64(l.rate)
+	}
+	return count
+}
+
+// wait blocks after a batch of n lines until the next batch may be sent.
+func (l *lineLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+
+	timer := time.NewTimer(time.Until(l.next))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,221 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"evolve/modules"
+	"evolve/util"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	sseProgressEvent = "progress" // Event name for a parsed logbook row.
+	stderrStream     = "stderr"   // Logbooks are only printed to stdout.
+	logbookPrimeSize = 50         // How many lines are searched for the header when resuming.
+)
+
+// logbookParser recognizes the lines DEAP prints for logbook.stream, i.e.
+// a header row followed by one row per generation, e.g.
+//
+//	gen	nevals	avg	min	max
+//	0	300	0.49	0	1
+//
+// Statistics with chapters (tools.MultiStatistics, as in GP) print the
+// chapter names and a dashed line above the header, and every chapter
+// repeats its own columns:
+//
+//	   	      	    fitness    	     size
+//	   	      	---------------	---------------
+//	gen	nevals	avg	max	min	std	avg	max	min	std
+//
+// The header is kept so that later rows can be turned into typed records.
+type logbookParser struct {
+	columns  []string
+	topLevel int      // Number of columns that do not belong to a chapter.
+	chapters []string // Chapters of the columns after the top level ones, in order.
+
+	// Chapter names seen above the next header.
+	pendingChapters []string
+	pendingTopLevel int
+}
+
+// line parses one line of output and returns the record
+// if it is a row of a logbook whose header was seen.
+func (p *logbookParser) line(text string) (map[string]any, bool) {
+	cells := strings.Split(strings.TrimRight(text, "\r"), "\t")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+
+	switch {
+	case len(cells) < 2:
+		return nil, false
+	case cells[0] == "gen" && allCells(cells, isLogbookName):
+		p.header(cells)
+		return nil, false
+	case cells[0] == "" && allCells(cells, isDashes):
+		return nil, false
+	case cells[0] == "" && allCells(cells, isLogbookName):
+		p.pendingChapters = nil
+		p.pendingTopLevel = 0
+		for _, cell := range cells {
+			if cell == "" && len(p.pendingChapters) == 0 {
+				p.pendingTopLevel++
+			} else if cell != "" {
+				p.pendingChapters = append(p.pendingChapters, cell)
+			}
+		}
+		return nil, false
+	case len(cells) != len(p.columns):
+		return nil, false
+	}
+
+	if _, err := strconv.Atoi(cells[0]); err != nil {
+		return nil, false
+	}
+
+	record := map[string]any{}
+	for i, cell := range cells {
+		value, ok := logbookValue(cell)
+		if !ok {
+			return nil, false
+		}
+
+		if i < p.topLevel || len(p.chapters) == 0 {
+			record[p.columns[i]] = value
+			continue
+		}
+
+		size := (len(p.columns) - p.topLevel) / len(p.chapters)
+		chapter := p.chapters[(i-p.topLevel)/size]
+		fields, ok := record[chapter].(map[string]any)
+		if !ok {
+			fields = map[string]any{}
+			record[chapter] = fields
+		}
+		fields[p.columns[i]] = value
+	}
+	return record, true
+}
+
+// header starts a new logbook. MultiStatistics registers the same
+// functions on every chapter, so the columns split evenly between them.
+func (p *logbookParser) header(cells []string) {
+	p.columns = cells
+	p.topLevel = len(cells)
+	p.chapters = nil
+
+	if n := len(p.pendingChapters); n > 0 && p.pendingTopLevel < len(cells) && (len(cells)-p.pendingTopLevel)%n == 0 {
+		p.topLevel = p.pendingTopLevel
+		p.chapters = p.pendingChapters
+	}
+	p.pendingChapters = nil
+	p.pendingTopLevel = 0
+}
+
+// payload parses the lines of a log payload sent by the runner
+// and returns the records of the logbook rows among them.
+func (p *logbookParser) payload(logPayload string) []map[string]any {
+	if p == nil {
+		return nil
+	}
+
+	var logData modules.LogLine
+	if json.Unmarshal([]byte(logPayload), &logData) != nil || logData.Stream == stderrStream {
+		return nil
+	}
+
+	var records []map[string]any
+	for _, text := range strings.Split(logData.Line, "\n") {
+		if record, ok := p.line(text); ok {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// primeLogbook feeds the parser the first lines of the stream when a
+// client starts after its beginning, so that the header is known.
+func primeLogbook(ctx context.Context, p *logbookParser, stream string, startID string) {
+	if startID == "0-0" {
+		return
+	}
+
+	messages, err := util.RedisClient.XRangeN(ctx, stream, "-", startID, logbookPrimeSize).Result()
+	if err != nil {
+		return
+	}
+	for _, msg := range messages {
+		if logPayloadStr, ok := msg.Values[modules.LogDataField].(string); ok {
+			p.payload(logPayloadStr)
+		}
+	}
+}
+
+// logbookValue converts a cell to a number, or to a list of numbers for
+// numpy arrays such as the average of multi-objective fitnesses. NaN and
+// infinite values, which JSON cannot represent, become null.
+func logbookValue(cell string) (any, bool) {
+	if cell == "" {
+		return nil, true
+	}
+
+	if strings.HasPrefix(cell, "[") && strings.HasSuffix(cell, "]") {
+		values := []any{}
+		for _, field := range strings.Fields(strings.Trim(cell, "[]")) {
+			value, ok := logbookValue(strings.TrimSuffix(field, ","))
+			if !ok {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+		return values, true
+	}
+
+	if n, err := strconv.ParseInt(cell, 10, 64); err == nil {
+		return n, true
+	}
+	f, err := strconv.ParseFloat(cell, 64)
+	if err != nil {
+		return nil, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, true
+	}
+	return f, true
+}
+
+// allCells reports whether every non-empty cell satisfies the check
+// and there is at least one of them.
+func allCells(cells []string, check func(string) bool) bool {
+	found := false
+	for _, cell := range cells {
+		if cell == "" {
+			continue
+		}
+		if !check(cell) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// isLogbookName reports whether the cell is a column or chapter name.
+func isLogbookName(cell string) bool {
+	for i, ch := range cell {
+		switch {
+		case ch == '_', ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z':
+		case ch >= '0' && ch <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isDashes(cell string) bool {
+	return strings.Trim(cell, "-") == ""
+}
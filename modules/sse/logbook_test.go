@@ -0,0 +1,107 @@
+package sse
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogbookParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			name: "ea",
+			lines: []string{
+				"Starting evolution",
+				"gen\tnevals\tavg    \tmin\tmax",
+				"0  \t300   \t0.49   \t0  \t1  ",
+				"1  \t181   \t1.5e-05\t0  \t2  ",
+			},
+			want: []string{
+				`{"avg":0.49,"gen":0,"max":1,"min":0,"nevals":300}`,
+				`{"avg":0.000015,"gen":1,"max":2,"min":0,"nevals":181}`,
+			},
+		},
+		{
+			name: "gp chapters",
+			lines: []string{
+				"   \t      \t              fitness              \t           size           ",
+				"   \t      \t-----------------------------------\t--------------------------",
+				"gen\tnevals\tavg    \tmax    \tmin  \tstd    \tavg \tmax\tmin\tstd  ",
+				"0  \t300   \t1.78e+07\t1.2e+09\t0.165\t9.8e+07\t3.69\t7  \t2  \t1.55",
+			},
+			want: []string{
+				`{"fitness":{"avg":17800000,"max":1200000000,"min":0.165,"std":98000000},"gen":0,"nevals":300,"size":{"avg":3.69,"max":7,"min":2,"std":1.55}}`,
+			},
+		},
+		{
+			name: "pso",
+			lines: []string{
+				"gen\tevals\tavg   \tstd  \tmin   \tmax",
+				"0  \t100  \t-12.5 \t3.1  \t-20   \tnan",
+			},
+			want: []string{
+				`{"avg":-12.5,"evals":100,"gen":0,"max":null,"min":-20,"std":3.1}`,
+			},
+		},
+		{
+			name: "multi-objective",
+			lines: []string{
+				"gen\tnevals\tavg                \tmin",
+				"0  \t50    \t[ 0.25  12.     ]\t[0 3]",
+			},
+			want: []string{
+				`{"avg":[0.25,12],"gen":0,"min":[0,3],"nevals":50}`,
+			},
+		},
+		{
+			name: "rows without header",
+			lines: []string{
+				"0  \t300   \t0.49   \t0  \t1  ",
+			},
+		},
+		{
+			name: "other output",
+			lines: []string{
+				"gen\tnevals\tavg\tmin\tmax",
+				"Best individual is [1, 0, 1]\twith fitness 3",
+				"0\t300\tnot a number\t0\t1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &logbookParser{}
+			var got []string
+			for _, line := range test.lines {
+				if record, ok := p.line(line); ok {
+					data, err := json.Marshal(record)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, string(data))
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLogbookParserPayload(t *testing.T) {
+	p := &logbookParser{}
+	p.payload(`{"stream": "stdout", "line": "gen\tnevals\tavg\nignored"}`)
+
+	if records := p.payload(`{"stream": "stderr", "line": "0\t10\t0.5"}`); len(records) != 0 {
+		t.Errorf("stderr lines must be ignored, got %v", records)
+	}
+	if records := p.payload(`{"stream": "stdout", "line": "0\t10\t0.5"}`); len(records) != 1 {
+		t.Errorf("expected one record, got %v", records)
+	}
+}
@@ -0,0 +1,511 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	maxMultiplexRuns   = 100              // Most runs one connection may stream.
+	activeRunsInterval = 30 * time.Second // How often the active runs of the user are looked up again.
+)
+
+// multiplexCursor is the position of every run of a multiplexed stream.
+// Runs that ended keep their position, so that a client resuming with it
+// does not receive them again.
+type multiplexCursor struct {
+	positions map[string]string
+	ended     map[string]bool
+	logbooks  map[string]*logbookParser
+	tail      int // Lines to send of runs read from the start, or -1 for all of them.
+}
+
+// logbook returns the logbook parser of the run.
+func (c *multiplexCursor) logbook(runID string) *logbookParser {
+	if c.logbooks[runID] == nil {
+		c.logbooks[runID] = &logbookParser{}
+	}
+	return c.logbooks[runID]
+}
+
+// reading returns the runs that have not ended yet, in sorted order.
+func (c *multiplexCursor) reading() []string {
+	var runIDs []string
+	for _, runID := range slices.Sorted(maps.Keys(c.positions)) {
+		if !c.ended[runID] {
+			runIDs = append(runIDs, runID)
+		}
+	}
+	return runIDs
+}
+
+// encode encodes the position of every run as runId=entryId pairs.
+func (c *multiplexCursor) encode() string {
+	pairs := make([]string, 0, len(c.positions))
+	for _, runID := range slices.Sorted(maps.Keys(c.positions)) {
+		pairs = append(pairs, runID+"="+c.positions[runID])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func decodeCursor(id string) (map[string]string, error) {
+	positions := map[string]string{}
+	for _, pair := range strings.Split(id, ",") {
+		runID, entryID, ok := strings.Cut(pair, "=")
+		if !ok || !streamIDPattern.MatchString(entryID) {
+			return nil, fmt.Errorf("invalid %s header: %s", lastEventHeader, id)
+		}
+		positions[runID] = entryID
+	}
+	return positions, nil
+}
+
+// multiplexLine is a log line of one of the runs of a multiplexed stream.
+type multiplexLine struct {
+	RunID string          `json:"runId"`
+	Log   json.RawMessage `json:"log"`
+}
+
+// GetMultiplexSSEHandler returns an HTTP handler that streams the logs of
+// several runs over one connection, reading all of them with one XREAD.
+//
+// The runs are given as ?runIds=a,b,c or default to every unfinished run
+// the user has access to, in which case runs started later are picked up
+// as well. Every event is tagged with its runId.
+func GetMultiplexSSEHandler(logger util.Logger) http.HandlerFunc {
+	if util.RedisClient == nil {
+		logger.Error("GetMultiplexSSEHandler requires a non-nil Redis client")
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error: Redis client not configured", http.StatusInternalServerError)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveMultiplexSSE(logger, w, r)
+	}
+}
+
+func serveMultiplexSSE(logger util.Logger, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger.Info("[SSE Multiplex Handler] Entered serveMultiplexSSE")
+
+	user, ok := modules.UserFromContext(ctx)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := modules.Authorize(user, modules.ActionRunRead); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	userID := user.ID
+
+	// Without a list of runs, follow the most recent active runs of the user.
+	var runIDs []string
+	var err error
+	follow := r.URL.Query().Get("runIds") == ""
+	if follow {
+		runIDs, err = modules.ActiveUserRuns(ctx, userID, maxMultiplexRuns, &logger)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		for _, runID := range strings.Split(r.URL.Query().Get("runIds"), ",") {
+			if runID = strings.TrimSpace(runID); runID != "" && !slices.Contains(runIDs, runID) {
+				runIDs = append(runIDs, runID)
+			}
+		}
+		if len(runIDs) > maxMultiplexRuns {
+			http.Error(w, fmt.Sprintf("At most %d runs can be streamed at once", maxMultiplexRuns), http.StatusBadRequest)
+			return
+		}
+		for _, runID := range runIDs {
+			_, err := modules.RunAccessMode(ctx, runID, user, &logger)
+			switch {
+			case errors.Is(err, modules.ErrRunNotFound):
+				http.Error(w, fmt.Sprintf("%s: %v", runID, err), http.StatusNotFound)
+				return
+			case errors.Is(err, modules.ErrRunAccessDenied):
+				http.Error(w, fmt.Sprintf("%s: %v", runID, err), http.StatusForbidden)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	cursor, err := startCursor(ctx, r, runIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Set SSE Headers.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryInterval); err != nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		logger.Error(fmt.Sprintf("[SSE Multiplex Handler] Error flushing headers for user %s: %v", userID, err))
+		return
+	}
+
+	events := &eventWriter{
+		w:       w,
+		rc:      rc,
+		array:   r.URL.Query().Get("batch") == "array",
+		limiter: newLineLimiter(linesPerSecond()),
+	}
+
+	logger.Info(fmt.Sprintf("[SSE Multiplex Handler] Streaming %d runs for user %s", len(cursor.reading()), userID))
+
+	// Logs of old runs have been moved to minIO.
+	if err := events.archivedRuns(ctx, cursor, cursor.reading(), &logger); err != nil {
+		if !isClientGone(err) {
+			logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] user=%s | error=%v", userID, err))
+		}
+		return
+	}
+
+	lastRefresh := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if follow && time.Since(lastRefresh) >= activeRunsInterval {
+			refreshCursor(ctx, cursor, userID, &logger)
+			lastRefresh = time.Now()
+		}
+
+		keys := cursor.reading()
+
+		// Nothing left to stream for an explicit list of runs.
+		if len(keys) == 0 && !follow {
+			_ = events.done()
+			return
+		}
+		if len(keys) == 0 {
+			if err := sleepContext(ctx, blockTimeout); err != nil {
+				return
+			}
+			continue
+		}
+
+		streams := make([]string, 0, 2*len(keys))
+		streams = append(streams, keys...)
+		for _, key := range keys {
+			streams = append(streams, cursor.positions[key])
+		}
+
+		results, err := util.RedisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: streams,
+			Count:   events.limiter.batchSize(streamReadCount),
+			Block:   blockTimeout,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				// Streams may have been archived while the client was catching up.
+				var started []string
+				for _, key := range keys {
+					if cursor.positions[key] != "0-0" {
+						started = append(started, key)
+					}
+				}
+				if err := events.archivedRuns(ctx, cursor, started, &logger); err != nil {
+					if !isClientGone(err) {
+						logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] user=%s | error=%v", userID, err))
+					}
+					return
+				}
+				continue
+			} else if errors.Is(err, context.Canceled) {
+				return
+			}
+			logger.Error(fmt.Sprintf("[SSE Multiplex Handler] Error reading streams for user %s: %v", userID, err))
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		lines, err := events.multiplexBatch(results, cursor, &logger)
+		if err != nil {
+			if !isClientGone(err) {
+				logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] user=%s | error=%v", userID, err))
+			}
+			return
+		}
+
+		if err := events.limiter.wait(ctx, lines); err != nil {
+			return
+		}
+	}
+}
+
+// multiplexBatch sends the messages of one XREAD over several streams with
+// a single flush and advances the cursor. Runs whose EOF marker was read
+// get a done event tagged with their runId and are no longer read.
+// The last event carries the cursor of all runs as its id, so a client can
+// resume every stream with Last-Event-ID. It returns the number of lines read.
+func (e *eventWriter) multiplexBatch(results []redis.XStream, cursor *multiplexCursor, logger *util.Logger) (int, error) {
+	var lines []multiplexLine
+	var progress []map[string]any
+	var ended []string
+	count := 0
+
+	for _, result := range results {
+		for _, msg := range result.Messages {
+			count++
+			cursor.positions[result.Stream] = msg.ID
+
+			logPayloadStr, ok := msg.Values[modules.LogDataField].(string)
+			if !ok {
+				logger.Warn(fmt.Sprintf("[SSE Multiplex Handler] Invalid data format in stream '%s', ID '%s'", result.Stream, msg.ID))
+				continue
+			}
+			lines = append(lines, multiplexLine{RunID: result.Stream, Log: jsonPayload(logPayloadStr)})
+			for _, record := range cursor.logbook(result.Stream).payload(logPayloadStr) {
+				record["runId"] = result.Stream
+				progress = append(progress, record)
+			}
+
+			var logData modules.LogLine
+			if json.Unmarshal([]byte(logPayloadStr), &logData) == nil && logData.Status == modules.LogEOFStatus {
+				ended = append(ended, result.Stream)
+				break
+			}
+		}
+	}
+
+	for _, runID := range ended {
+		cursor.ended[runID] = true
+	}
+	id := cursor.encode()
+
+	if e.array && len(lines) > 0 {
+		data, err := json.Marshal(lines)
+		if err != nil {
+			return 0, err
+		}
+		if err := e.write(id, "", string(data)); err != nil {
+			return 0, err
+		}
+	} else {
+		for i, line := range lines {
+			data, err := json.Marshal(line)
+			if err != nil {
+				return 0, err
+			}
+			// Only the last event needs the cursor.
+			lineID := ""
+			if i == len(lines)-1 && len(ended) == 0 {
+				lineID = id
+			}
+			if err := e.write(lineID, "", string(data)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Progress events carry no id, like for a single run.
+	if err := e.progress(progress); err != nil {
+		return 0, err
+	}
+
+	for i, runID := range ended {
+		doneID := ""
+		if i == len(ended)-1 {
+			doneID = id
+		}
+		data, _ := json.Marshal(map[string]string{"runId": runID, "message": "Stream ended."})
+		if err := e.write(doneID, sseDoneEvent, string(data)); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, e.rc.Flush()
+}
+
+// archivedRuns sends the archived logs of the runs whose stream no longer
+// exists, which ends them. Runs whose stream has not been created yet, and
+// so have no archive either, are left to XREAD.
+func (e *eventWriter) archivedRuns(ctx context.Context, cursor *multiplexCursor, runIDs []string, logger *util.Logger) error {
+	for _, runID := range runIDs {
+		if n, err := util.RedisClient.Exists(ctx, runID).Result(); err != nil || n > 0 {
+			continue
+		}
+
+		entries, err := modules.ArchivedRunLogs(ctx, runID, logger)
+		if err != nil {
+			if !errors.Is(err, modules.ErrLogsNotFound) {
+				logger.Error(fmt.Sprintf("[SSE Multiplex Handler] Error reading archived logs of runId %s: %v", runID, err))
+			}
+			continue
+		}
+		logger.Info(fmt.Sprintf("[SSE Multiplex Handler] Serving archived logs for runId: %s after ID: %s", runID, cursor.positions[runID]))
+
+		if err := e.archivedRun(ctx, cursor, runID, entries, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivedRun sends the archived entries of a run after its position and
+// ends the run. A client that had already received them gets nothing.
+func (e *eventWriter) archivedRun(ctx context.Context, cursor *multiplexCursor, runID string, entries []modules.LogEntry, logger *util.Logger) error {
+	lastID := cursor.positions[runID]
+	start := 0
+	for start < len(entries) && compareStreamIDs(entries[start].ID, lastID) <= 0 {
+		start++
+	}
+	// tailID cannot tell the last lines of an archived stream.
+	if cursor.tail >= 0 && lastID == "0-0" && cursor.tail < len(entries)-start {
+		start = len(entries) - cursor.tail
+	}
+	if start == len(entries) && start > 0 {
+		// Everything up to the EOF marker was sent before.
+		cursor.ended[runID] = true
+		return nil
+	}
+	entries = entries[start:]
+
+	for len(entries) > 0 && !cursor.ended[runID] {
+		n := min(len(entries), int(e.limiter.batchSize(streamReadCount)))
+		messages := make([]redis.XMessage, n)
+		for i, entry := range entries[:n] {
+			messages[i] = redis.XMessage{ID: entry.ID, Values: map[string]any{modules.LogDataField: string(entry.Log)}}
+		}
+		entries = entries[n:]
+
+		lines, err := e.multiplexBatch([]redis.XStream{{Stream: runID, Messages: messages}}, cursor, logger)
+		if err != nil {
+			return err
+		}
+		if err := e.limiter.wait(ctx, lines); err != nil {
+			return err
+		}
+	}
+
+	// Archives end with the EOF marker, but the run ends either way.
+	if !cursor.ended[runID] {
+		cursor.ended[runID] = true
+		data, _ := json.Marshal(map[string]string{"runId": runID, "message": "Stream ended."})
+		if err := e.write(cursor.encode(), sseDoneEvent, string(data)); err != nil {
+			return err
+		}
+		return e.rc.Flush()
+	}
+	return nil
+}
+
+// startCursor returns the position to start reading each run from. A
+// reconnecting client sends the cursor of its last event as Last-Event-ID,
+// otherwise ?tail=N starts every run with its last N lines.
+func startCursor(ctx context.Context, r *http.Request, runIDs []string) (*multiplexCursor, error) {
+	cursor := &multiplexCursor{positions: map[string]string{}, ended: map[string]bool{}, logbooks: map[string]*logbookParser{}, tail: -1}
+
+	resumed := map[string]string{}
+	if id := r.Header.Get(lastEventHeader); id != "" {
+		var err error
+		if resumed, err = decodeCursor(id); err != nil {
+			return nil, err
+		}
+	}
+
+	tail := -1
+	if value := r.URL.Query().Get("tail"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid tail query parameter: %s", value)
+		}
+		tail = n
+	}
+	cursor.tail = tail
+
+	for _, runID := range runIDs {
+		switch {
+		case resumed[runID] != "":
+			cursor.positions[runID] = resumed[runID]
+			// Runs that had already ended when the client last
+			// received an event are not streamed again.
+			if ended, err := streamEnded(ctx, runID, resumed[runID]); err == nil && ended {
+				cursor.ended[runID] = true
+			}
+		case tail >= 0:
+			id, err := tailID(ctx, runID, tail)
+			if err != nil {
+				return nil, err
+			}
+			cursor.positions[runID] = id
+		default:
+			cursor.positions[runID] = "0-0"
+		}
+		primeLogbook(ctx, cursor.logbook(runID), runID, cursor.positions[runID])
+	}
+
+	return cursor, nil
+}
+
+// refreshCursor adds runs the user started since the last refresh, most
+// recent first and up to maxMultiplexRuns in total, and forgets runs that
+// finished, once they have been read fully. Runs that finished without an
+// EOF marker (e.g. cancelled while queued) are dropped the same way.
+func refreshCursor(ctx context.Context, cursor *multiplexCursor, userID string, logger *util.Logger) {
+	runIDs, err := modules.ActiveUserRuns(ctx, userID, maxMultiplexRuns, logger)
+	if err != nil {
+		return
+	}
+
+	for runID, id := range cursor.positions {
+		if slices.Contains(runIDs, runID) {
+			continue
+		}
+		if cursor.ended[runID] {
+			delete(cursor.positions, runID)
+			continue
+		}
+		remaining, err := util.RedisClient.XRangeN(ctx, runID, "("+id, "+", 1).Result()
+		if err == nil && len(remaining) == 0 {
+			delete(cursor.positions, runID)
+		}
+	}
+
+	for _, runID := range runIDs {
+		if _, ok := cursor.positions[runID]; !ok && len(cursor.positions) < maxMultiplexRuns {
+			cursor.positions[runID] = "0-0"
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"evolve/modules"
+	"evolve/util"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMultiplexArchivedAndLiveRun(t *testing.T) {
+	archived := []modules.LogEntry{
+		{ID: "1-0", Log: json.RawMessage(`{"stream":"stdout","line":"first"}`)},
+		{ID: "2-0", Log: json.RawMessage(`{"stream":"stdout","line":"second"}`)},
+		{ID: "3-0", Log: json.RawMessage(`{"status":"EOF","runId":"archived"}`)},
+	}
+	live := redis.XStream{Stream: "live", Messages: []redis.XMessage{
+		{ID: "10-0", Values: map[string]any{modules.LogDataField: `{"stream":"stdout","line":"running"}`}},
+	}}
+
+	newWriter := func() (*eventWriter, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		return &eventWriter{w: rec, rc: http.NewResponseController(rec), limiter: newLineLimiter(0)}, rec
+	}
+	newCursor := func(positions map[string]string) *multiplexCursor {
+		return &multiplexCursor{positions: positions, ended: map[string]bool{}, logbooks: map[string]*logbookParser{}, tail: -1}
+	}
+	ctx := context.Background()
+	logger := util.NewLogger()
+
+	events, rec := newWriter()
+	cursor := newCursor(map[string]string{"archived": "0-0", "live": "0-0"})
+	if err := events.archivedRun(ctx, cursor, "archived", archived, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events.multiplexBatch([]redis.XStream{live}, cursor, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`"runId":"archived","log":{"stream":"stdout","line":"first"}`,
+		`"runId":"archived","log":{"stream":"stdout","line":"second"}`,
+		"event: done\ndata: {\"message\":\"Stream ended.\",\"runId\":\"archived\"}",
+		`"runId":"live","log":{"stream":"stdout","line":"running"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream is missing %s:\n%s", want, body)
+		}
+	}
+	if got := cursor.reading(); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("reading() = %v, want only the live run", got)
+	}
+	if got, want := cursor.encode(), "archived=3-0,live=10-0"; got != want {
+		t.Errorf("encode() = %s, want %s", got, want)
+	}
+
+	// A client resuming after the EOF marker gets nothing more.
+	events, rec = newWriter()
+	cursor = newCursor(map[string]string{"archived": "3-0", "live": "10-0"})
+	if err := events.archivedRun(ctx, cursor, "archived", archived, logger); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.Len() != 0 || !cursor.ended["archived"] {
+		t.Errorf("resumed archived run: ended = %v, sent %q", cursor.ended["archived"], rec.Body.String())
+	}
+
+	// With ?tail=1 only the EOF marker of the archive is left.
+	events, rec = newWriter()
+	cursor = newCursor(map[string]string{"archived": "0-0"})
+	cursor.tail = 1
+	if err := events.archivedRun(ctx, cursor, "archived", archived, logger); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rec.Body.String(), "second") || !strings.Contains(rec.Body.String(), "event: done") {
+		t.Errorf("tail=1 sent:\n%s", rec.Body.String())
+	}
+}
@@ -1,12 +1,16 @@
 package sse
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
+	"evolve/modules"
 	"evolve/util"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,22 +19,15 @@ import (
 
 const (
 	runIdHeader     = "X-RUN-ID"      // Header key for the run ID.
-	retrySeconds    = 3               // SSE retry interval suggestion for clients.
+	lastEventHeader = "Last-Event-ID" // Header sent by EventSource when reconnecting.
+	retryInterval   = 3000            // SSE retry interval suggestion for clients, in milliseconds.
 	sseDoneEvent    = "done"          // Event name for the end of the stream.
-	eofStatus       = "EOF"           // Expected status value for the end message.
-	logDataField    = "log_data"      // Field name in Redis Stream (must match 'runner').
 	streamReadCount = 100             // How many messages to read per XREAD call.
 	blockTimeout    = 5 * time.Second // Block timeout for XREAD waiting for new messages.
 )
 
-// redisLogPayload represents the structure
-// of the log data from Redis.
-type redisLogPayload struct {
-	Stream string `json:"stream"` // stdout or stderr.
-	Line   string `json:"line"`
-	Status string `json:"status"` // Check for EOF status here.
-	RunID  string `json:"runId"`  // From EOF message.
-}
+// streamIDPattern matches Redis stream entry IDs, e.g. 1700000000000-0.
+var streamIDPattern = regexp.MustCompile(`^\d+(-\d+)?$`)
 
 // GetSSEHandler returns an HTTP handler
 // for Server-Sent Events (SSE) using Redis Streams.
@@ -46,25 +43,9 @@ func GetSSEHandler(logger util.Logger) http.HandlerFunc {
 	}
 }
 
-func sendSSEData(w http.ResponseWriter, rc *http.ResponseController, payload string, runId string, logger *util.Logger) bool {
-	// logger.Info(fmt.Sprintf("[SSE SENDING DATA] runId=%s | data=%s", runId, payload)) // Debug log
-	_, writeErr := fmt.Fprintf(w, "data: %s\n\n", payload) // Payload should already be JSON string
-	if writeErr != nil {
-		// Don't log excessive errors if client simply disconnected
-		if !errors.Is(writeErr, context.Canceled) && !strings.Contains(writeErr.Error(), "client disconnected") && !strings.Contains(writeErr.Error(), "connection reset by peer") {
-			logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] runId=%s | error=%v", runId, writeErr))
-		}
-		return false // Indicate failure
-	}
-	if flushErr := rc.Flush(); flushErr != nil {
-		if !errors.Is(flushErr, context.Canceled) && !strings.Contains(flushErr.Error(), "client disconnected") {
-			logger.Error(fmt.Sprintf("[SSE FLUSH ERROR] runId=%s | error=%v", runId, flushErr))
-		}
-		return false // Indicate failure
-	}
-
-	time.Sleep(256 * time.Millisecond)
-	return true // Indicate success
+// isClientGone reports whether a write failed because the client disconnected.
+func isClientGone(err error) bool {
+	return errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "client disconnected") || strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "broken pipe")
 }
 
 // serveSSEWithStream handles the SSE stream for a given run ID.
@@ -92,16 +73,30 @@ func serveSSEWithStream(logger util.Logger, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Authenticate with the cookie or a stream token and check read access.
+	if status, err := modules.AuthorizeRunStream(r, runId, &logger); err != nil {
+		logger.Warn(fmt.Sprintf("[SSE Stream Handler] Access to runId %s denied: %v", runId, err))
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	redisStreamName := runId
 	logger.Info(fmt.Sprintf("[SSE Stream Handler] Determined runId: '%s', Stream Name: '%s'", runId, redisStreamName))
 
+	lastProcessedID, err := startID(ctx, r, redisStreamName)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("[SSE Stream Handler] Invalid start position for runId %s: %v", runId, err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Set SSE Headers.
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no")
 
-	_, err := fmt.Fprintf(w, "retry: %ds\n\n", retrySeconds)
+	_, err = fmt.Fprintf(w, "retry: %d\n\n", retryInterval)
 	if err != nil {
 		logger.Error(fmt.Sprintf("[SSE Stream Handler] Error writing retry header for runId %s: %v", runId, err))
 		return
@@ -118,117 +113,43 @@ func serveSSEWithStream(logger util.Logger, w http.ResponseWriter, r *http.Reque
 	}
 	logger.Info(fmt.Sprintf("[SSE Stream Handler] Flushed SSE headers for runId: %s", runId))
 
-	// sendSSEData := func(payload string) bool {
-	// 	// logger.Info(fmt.Sprintf("[SSE SENDING DATA] runId=%s | data=%s", runId, payload)) // Debug log
-	// 	_, writeErr := fmt.Fprintf(w, "data: %s\n\n", payload) // Payload should already be JSON string
-	// 	if writeErr != nil {
-	// 		// Don't log excessive errors if client simply disconnected
-	// 		if !errors.Is(writeErr, context.Canceled) && !strings.Contains(writeErr.Error(), "client disconnected") && !strings.Contains(writeErr.Error(), "connection reset by peer") {
-	// 			logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] runId=%s | error=%v", runId, writeErr))
-	// 		}
-	// 		return false // Indicate failure
-	// 	}
-	// 	if flushErr := rc.Flush(); flushErr != nil {
-	// 		if !errors.Is(flushErr, context.Canceled) && !strings.Contains(flushErr.Error(), "client disconnected") {
-	// 			logger.Error(fmt.Sprintf("[SSE FLUSH ERROR] runId=%s | error=%v", runId, flushErr))
-	// 		}
-	// 		return false // Indicate failure
-	// 	}
-
-	// 	time.Sleep(256 * time.Millisecond)
-	// 	return true // Indicate success
-	// }
-
-	// Process Stream.
-
-	// Read History from beginning.
-	lastProcessedID := "0-0"
-	logger.Info(fmt.Sprintf("[SSE Stream Handler] Reading historical logs for stream: '%s' from ID: %s", redisStreamName, lastProcessedID))
-	historyProcessed := 0
-
-	for {
-		// Use XRead to get batches of historical data
-		// We don't block here, just read what's available.
-		cmd := util.RedisClient.XRead(ctx, &redis.XReadArgs{
-			Streams: []string{redisStreamName, lastProcessedID},
-			Count:   streamReadCount,
-		})
-		results, err := cmd.Result()
-
-		if err != nil {
-			// If stream doesn't exist yet, redis.Nil might not be returned by XRead.
-			// Check error message for "NOGROUP" or similar if needed, but often just means empty.
-			// For now, assume empty stream is not an error, just means no history.
-			if errors.Is(err, redis.Nil) || strings.Contains(err.Error(), "NOGROUP") {
-				logger.Info(fmt.Sprintf("[SSE Stream Handler] No historical logs found or reached end for stream: '%s'", redisStreamName))
-				break
-			} else if errors.Is(err, context.Canceled) {
-				// Client disconnected.
-				logger.Info(fmt.Sprintf("[SSE Stream Handler] Context cancelled during history read for runId: %s", runId))
-				return
-			}
-			logger.Error(fmt.Sprintf("[SSE Stream Handler] Error reading history from stream '%s': %v", redisStreamName, err))
-			return
-		}
-
-		if len(results) == 0 || len(results[0].Messages) == 0 {
-			// logger.Info(fmt.Sprintf("[SSE Stream Handler] Finished reading history batch for stream: '%s'", redisStreamName))
-			break
-		}
-
-		streamMessages := results[0].Messages
-		logger.Info(fmt.Sprintf("[SSE Stream Handler] Processing %d historical messages for stream: '%s'", len(streamMessages), redisStreamName))
-
-		for _, msg := range streamMessages {
-			logPayloadStr, ok := msg.Values[logDataField].(string)
-			if !ok {
-				logger.Warn(fmt.Sprintf("[SSE Stream Handler] Invalid data format in stream '%s', ID '%s': Missing or non-string field '%s'", redisStreamName, msg.ID, logDataField))
-				continue
-			}
-
-			// Send the payload.
-			if !sendSSEData(w, rc, logPayloadStr, runId, &logger) {
-				return
-			}
-			historyProcessed++
-
-			// Check if this message is the EOF marker.
-			var logData redisLogPayload
-			if json.Unmarshal([]byte(logPayloadStr), &logData) == nil && logData.Status == eofStatus {
-				logger.Info(fmt.Sprintf("[SSE Stream Handler] EOF marker found in history (ID: %s) for runId: %s", msg.ID, runId))
-				// Send the done event *now* and finish.
-				doneData := `{"message": "Stream ended (found in history)."}`
-				_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseDoneEvent, doneData)
-				_ = rc.Flush()
-				return
-			}
-			// Update last ID processed.
-			lastProcessedID = msg.ID
-		}
+	events := &eventWriter{
+		w:       w,
+		rc:      rc,
+		array:   r.URL.Query().Get("batch") == "array",
+		limiter: newLineLimiter(linesPerSecond()),
+		logbook: &logbookParser{},
+	}
+	primeLogbook(ctx, events.logbook, redisStreamName, lastProcessedID)
 
-		// If we read less than requested count, we are likely at the end of history for now.
-		if len(streamMessages) < streamReadCount {
-			break
-		}
+	// Logs of old runs have been moved to minIO.
+	if served := serveArchivedLogs(ctx, r, events, runId, lastProcessedID, &logger); served {
+		return
 	}
 
-	logger.Info(fmt.Sprintf("[SSE Stream Handler] Finished reading history (%d entries) for stream: '%s'. Last ID: %s. Starting live block.", historyProcessed, redisStreamName, lastProcessedID))
+	// A client resuming after the EOF marker has nothing left to read.
+	if ended, err := streamEnded(ctx, redisStreamName, lastProcessedID); err == nil && ended {
+		logger.Info(fmt.Sprintf("[SSE Stream Handler] Stream already ended before ID %s for runId: %s", lastProcessedID, runId))
+		_ = events.done()
+		return
+	}
 
-	// Read Live Updates (Blocking).
+	// Process Stream. XREAD returns right away while there is history
+	// to catch up on and blocks once the client is up to date.
+	logger.Info(fmt.Sprintf("[SSE Stream Handler] Reading logs for stream: '%s' after ID: %s", redisStreamName, lastProcessedID))
 	for {
 		// Check context before blocking read.
 		select {
 		case <-ctx.Done():
-			logger.Info(fmt.Sprintf("[SSE Stream Handler] Context done before blocking read for runId %s: %v", runId, ctx.Err()))
+			logger.Info(fmt.Sprintf("[SSE Stream Handler] Context done before read for runId %s: %v", runId, ctx.Err()))
 			return
 		default:
 			// Continue to blocking read.
 		}
 
-		// logger.Info(fmt.Sprintf("[SSE Stream Handler] Blocking read on stream '%s' from ID: %s", redisStreamName, lastProcessedID))
 		cmd := util.RedisClient.XRead(ctx, &redis.XReadArgs{
 			Streams: []string{redisStreamName, lastProcessedID},
-			Count:   streamReadCount,
+			Count:   events.limiter.batchSize(streamReadCount),
 			Block:   blockTimeout,
 		})
 		results, err := cmd.Result()
@@ -236,48 +157,171 @@ func serveSSEWithStream(logger util.Logger, w http.ResponseWriter, r *http.Reque
 		if err != nil {
 			// redis.Nil means the block timeout was reached, no new messages.
 			if errors.Is(err, redis.Nil) {
-				// logger.Debug(fmt.Sprintf("[SSE Stream Handler] Block timeout reached for stream '%s', continuing loop.", redisStreamName))
+				// The stream may have been archived while the client was catching up.
+				if lastProcessedID == "0-0" {
+					continue
+				}
+				if served := serveArchivedLogs(ctx, r, events, runId, lastProcessedID, &logger); served {
+					return
+				}
 				continue
 			} else if errors.Is(err, context.Canceled) {
 				// Client disconnected.
-				logger.Info(fmt.Sprintf("[SSE Stream Handler] Context cancelled during blocking read for runId %s: %v", runId, ctx.Err()))
+				logger.Info(fmt.Sprintf("[SSE Stream Handler] Context cancelled during read for runId %s: %v", runId, ctx.Err()))
 				return
 			}
 
-			logger.Error(fmt.Sprintf("[SSE Stream Handler] Error during blocking read for stream '%s': %v", redisStreamName, err))
+			logger.Error(fmt.Sprintf("[SSE Stream Handler] Error reading stream '%s': %v", redisStreamName, err))
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
-		// Process new messages if any.
-		if len(results) > 0 && len(results[0].Messages) > 0 {
-			streamMessages := results[0].Messages
-			// logger.Info(fmt.Sprintf("[SSE Stream Handler] Processing %d live messages for stream: '%s'", len(streamMessages), redisStreamName))
+		if len(results) == 0 || len(results[0].Messages) == 0 {
+			continue
+		}
 
-			for _, msg := range streamMessages {
-				logPayloadStr, ok := msg.Values[logDataField].(string)
-				if !ok {
-					logger.Warn(fmt.Sprintf("[SSE Stream Handler] Invalid live data format in stream '%s', ID '%s': Missing or non-string field '%s'", redisStreamName, msg.ID, logDataField))
-					continue
-				}
+		lastID, ended, err := events.batch(results[0].Messages, &logger)
+		if err != nil {
+			if !isClientGone(err) {
+				logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] runId=%s | error=%v", runId, err))
+			}
+			return
+		}
+		lastProcessedID = lastID
 
-				// Send the payload.
-				if !sendSSEData(w, rc, logPayloadStr, runId, &logger) {
-					return
-				}
+		if ended {
+			logger.Info(fmt.Sprintf("[SSE Stream Handler] EOF marker found (ID: %s) for runId: %s. Sent done event.", lastProcessedID, runId))
+			return
+		}
 
-				// Check if this message is the EOF marker.
-				var logData redisLogPayload
-				if json.Unmarshal([]byte(logPayloadStr), &logData) == nil && logData.Status == eofStatus {
-					logger.Info(fmt.Sprintf("[SSE Stream Handler] Live EOF marker found (ID: %s) for runId: %s. Sending done event.", msg.ID, runId))
-					doneData := `{"message": "Stream ended."}`
-					_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseDoneEvent, doneData)
-					_ = rc.Flush()
-					return
-				}
-				// Update last ID processed.
-				lastProcessedID = msg.ID
-			}
+		if err := events.limiter.wait(ctx, len(results[0].Messages)); err != nil {
+			return
+		}
+	}
+}
+
+// serveArchivedLogs sends the logs of a run whose stream has been
+// archived, after lastID, and ends the stream. It reports whether the
+// logs were served, which is not the case while the stream exists.
+func serveArchivedLogs(ctx context.Context, r *http.Request, events *eventWriter, runId string, lastID string, logger *util.Logger) bool {
+	if n, err := util.RedisClient.Exists(ctx, runId).Result(); err != nil || n > 0 {
+		return false
+	}
+
+	entries, err := modules.ArchivedRunLogs(ctx, runId, logger)
+	if err != nil {
+		if !errors.Is(err, modules.ErrLogsNotFound) {
+			logger.Error(fmt.Sprintf("[SSE Stream Handler] Error reading archived logs of runId %s: %v", runId, err))
+		}
+		return false
+	}
+	logger.Info(fmt.Sprintf("[SSE Stream Handler] Serving archived logs for runId: %s after ID: %s", runId, lastID))
+
+	start := 0
+	for start < len(entries) && compareStreamIDs(entries[start].ID, lastID) <= 0 {
+		start++
+	}
+	// startID cannot tell the last lines of an archived stream.
+	if tail, err := strconv.Atoi(r.URL.Query().Get("tail")); err == nil && lastID == "0-0" && tail < len(entries)-start {
+		start = len(entries) - tail
+	}
+	entries = entries[start:]
+
+	for len(entries) > 0 {
+		n := min(len(entries), int(events.limiter.batchSize(streamReadCount)))
+		messages := make([]redis.XMessage, n)
+		for i, entry := range entries[:n] {
+			messages[i] = redis.XMessage{ID: entry.ID, Values: map[string]any{modules.LogDataField: string(entry.Log)}}
+		}
+		entries = entries[n:]
+
+		_, ended, err := events.batch(messages, logger)
+		if err != nil || ended {
+			return true
+		}
+		if err := events.limiter.wait(ctx, n); err != nil {
+			return true
 		}
 	}
+
+	_ = events.done()
+	return true
+}
+
+// startID returns the stream entry ID after which to start sending.
+// In order of precedence it is taken from the Last-Event-ID header of a
+// reconnecting client, the from query parameter, or the tail query
+// parameter (the last N lines). Without any of them the whole stream is sent.
+func startID(ctx context.Context, r *http.Request, stream string) (string, error) {
+	if id := r.Header.Get(lastEventHeader); id != "" {
+		if !streamIDPattern.MatchString(id) {
+			return "", fmt.Errorf("invalid %s header: %s", lastEventHeader, id)
+		}
+		return id, nil
+	}
+
+	query := r.URL.Query()
+	if id := query.Get("from"); id != "" {
+		if !streamIDPattern.MatchString(id) {
+			return "", fmt.Errorf("invalid from query parameter: %s", id)
+		}
+		return id, nil
+	}
+
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid tail query parameter: %s", tail)
+		}
+		return tailID(ctx, stream, n)
+	}
+
+	return "0-0", nil
+}
+
+// tailID returns the ID before the last n entries of the stream,
+// since XREAD only returns entries after the given ID.
+func tailID(ctx context.Context, stream string, n int) (string, error) {
+	messages, err := util.RedisClient.XRevRangeN(ctx, stream, "+", "-", int64(n+1)).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) <= n {
+		return "0-0", nil
+	}
+	return messages[n].ID, nil
+}
+
+// streamEnded reports whether the last entry of the stream is the
+// EOF marker and it was already sent, i.e. it is not after lastID.
+func streamEnded(ctx context.Context, stream string, lastID string) (bool, error) {
+	messages, err := util.RedisClient.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+	if err != nil || len(messages) == 0 {
+		return false, err
+	}
+	if compareStreamIDs(messages[0].ID, lastID) > 0 {
+		return false, nil
+	}
+
+	logPayloadStr, _ := messages[0].Values[modules.LogDataField].(string)
+	var logData modules.LogLine
+	return json.Unmarshal([]byte(logPayloadStr), &logData) == nil && logData.Status == modules.LogEOFStatus, nil
+}
+
+// compareStreamIDs compares two stream entry IDs like strings.Compare.
+// A missing sequence number is treated as 0.
+func compareStreamIDs(a string, b string) int {
+	parse := func(id string) (uint64, uint64) {
+		ms, seq, _ := strings.Cut(id, "-")
+		msValue, _ := strconv.ParseUint(ms, 10, 64)
+		seqValue, _ := strconv.ParseUint(seq, 10, 64)
+		return msValue, seqValue
+	}
+
+	aMs, aSeq := parse(a)
+	bMs, bSeq := parse(b)
+	if aMs != bMs {
+		return cmp.Compare(aMs, bMs)
+	}
+	return cmp.Compare(aSeq, bSeq)
 }
@@ -0,0 +1,175 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/modules"
+	"evolve/util"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GetStatusSSEHandler returns an HTTP handler that streams the status
+// changes of a run as named events (queued, started, progress, completed,
+// failed and cancelled), read from the status stream of the run.
+func GetStatusSSEHandler(logger util.Logger) http.HandlerFunc {
+	if util.RedisClient == nil {
+		logger.Error("GetStatusSSEHandler requires a non-nil Redis client")
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error: Redis client not configured", http.StatusInternalServerError)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveStatusSSE(logger, w, r)
+	}
+}
+
+func serveStatusSSE(logger util.Logger, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	runId := r.URL.Query().Get("runId")
+	if runId == "" {
+		runId = r.Header.Get(runIdHeader)
+	}
+	if runId == "" {
+		http.Error(w, fmt.Sprintf("Missing runId query parameter or %s header", runIdHeader), http.StatusBadRequest)
+		return
+	}
+
+	// Authenticate with the cookie or a stream token and check read access.
+	if status, err := modules.AuthorizeRunStream(r, runId, &logger); err != nil {
+		logger.Warn(fmt.Sprintf("[SSE Status Handler] Access to runId %s denied: %v", runId, err))
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	stream := util.RunStatusStream(runId)
+	lastProcessedID, err := startID(ctx, r, stream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Set SSE Headers.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryInterval); err != nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		logger.Error(fmt.Sprintf("[SSE Status Handler] Error flushing headers for runId %s: %v", runId, err))
+		return
+	}
+
+	events := &eventWriter{w: w, rc: rc}
+
+	ended, err := statusEnded(ctx, stream, lastProcessedID, runId, events, &logger)
+	if err != nil {
+		if !isClientGone(err) {
+			logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] runId=%s | error=%v", runId, err))
+		}
+		return
+	}
+	if ended {
+		_ = events.done()
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		results, err := util.RedisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{stream, lastProcessedID},
+			Count:   streamReadCount,
+			Block:   blockTimeout,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			} else if errors.Is(err, context.Canceled) {
+				return
+			}
+			logger.Error(fmt.Sprintf("[SSE Status Handler] Error reading stream '%s': %v", stream, err))
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		for _, msg := range results[0].Messages {
+			lastProcessedID = msg.ID
+
+			event, ok := statusEvent(msg)
+			if !ok {
+				logger.Warn(fmt.Sprintf("[SSE Status Handler] Invalid status event in stream '%s', ID '%s'", stream, msg.ID))
+				continue
+			}
+			if err := events.write(msg.ID, event.Status, msg.Values[util.StatusEventField].(string)); err != nil {
+				if !isClientGone(err) {
+					logger.Warn(fmt.Sprintf("[SSE WRITE ERROR] runId=%s | error=%v", runId, err))
+				}
+				return
+			}
+			if modules.IsRunFinished(event.Status) {
+				_ = events.done()
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// statusEnded reports whether the run has finished and the client has
+// already received its last status. Runs without any status event (e.g.
+// submitted before status events existed) get their stored status instead.
+func statusEnded(ctx context.Context, stream string, lastID string, runID string, events *eventWriter, logger *util.Logger) (bool, error) {
+	messages, err := util.RedisClient.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+	if err != nil {
+		return false, nil
+	}
+
+	if len(messages) == 0 {
+		event, err := modules.RunStatus(ctx, runID, logger)
+		if err != nil {
+			return false, nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return false, err
+		}
+		if err := events.write("", event.Status, string(data)); err != nil {
+			return false, err
+		}
+		return modules.IsRunFinished(event.Status), events.rc.Flush()
+	}
+
+	if compareStreamIDs(messages[0].ID, lastID) > 0 {
+		return false, nil
+	}
+	event, ok := statusEvent(messages[0])
+	return ok && modules.IsRunFinished(event.Status), nil
+}
+
+// statusEvent decodes the status event of a stream entry.
+func statusEvent(msg redis.XMessage) (util.RunStatusEvent, bool) {
+	var event util.RunStatusEvent
+	payload, ok := msg.Values[util.StatusEventField].(string)
+	if !ok || json.Unmarshal([]byte(payload), &event) != nil || event.Status == "" {
+		return event, false
+	}
+	return event, true
+}
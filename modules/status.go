@@ -0,0 +1,94 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"evolve/db/connection"
+	"evolve/util"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// runnerStatuses are the statuses runners may report on the status channel.
+var runnerStatuses = []string{RunStatusStarted, RunStatusProgress, RunStatusCompleted, RunStatusFailed, RunStatusCancelled}
+
+// MonitorRunStatus records the status events runners publish on the
+// status channel. It blocks until ctx is cancelled.
+func MonitorRunStatus(ctx context.Context, logger util.Logger) {
+	logger.Info(fmt.Sprintf("Run status monitor started on channel %s.", util.RunStatusChannel()))
+
+	sub := util.SubscribeRunStatus(ctx)
+	defer sub.Close()
+
+	// The channel is kept open across reconnects.
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Run status monitor stopped.")
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				logger.Warn("Run status monitor: subscription closed.")
+				return
+			}
+			handleRunStatusMessage(ctx, msg.Payload, &logger)
+		}
+	}
+}
+
+// handleRunStatusMessage stores a status reported by a runner and
+// sends it to the status stream of the run. Progress is only sent.
+func handleRunStatusMessage(ctx context.Context, payload string, logger *util.Logger) {
+	var event util.RunStatusEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil || event.RunID == "" {
+		logger.Warn(fmt.Sprintf("MonitorRunStatus: invalid status message: %s", payload))
+		return
+	}
+	if !slices.Contains(runnerStatuses, event.Status) {
+		logger.Warn(fmt.Sprintf("MonitorRunStatus: unknown status %q for run %s", event.Status, event.RunID))
+		return
+	}
+
+	claimed, err := util.ClaimRunStatusMessage(ctx, payload)
+	if err != nil {
+		logger.Error(fmt.Sprintf("MonitorRunStatus: %v", err))
+		return
+	}
+	if !claimed {
+		// Handled by another instance.
+		return
+	}
+
+	if event.Status == RunStatusProgress {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		if err := util.AppendRunStatusEvent(ctx, event); err != nil {
+			logger.Error(fmt.Sprintf("MonitorRunStatus: failed to send progress of run %s: %v", event.RunID, err))
+		}
+		return
+	}
+
+	if err := setRunStatus(ctx, event.RunID, event.Status, event.Reason, logger); err != nil {
+		logger.Error(fmt.Sprintf("MonitorRunStatus: failed to set status %s of run %s: %v", event.Status, event.RunID, err))
+	}
+}
+
+// RunStatus returns the stored status of a run as a status event.
+func RunStatus(ctx context.Context, runID string, logger *util.Logger) (util.RunStatusEvent, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RunStatus: %s", err.Error()))
+		return util.RunStatusEvent{}, fmt.Errorf("something went wrong")
+	}
+
+	event := util.RunStatusEvent{RunID: runID}
+	err = db.QueryRow(ctx, "SELECT status, COALESCE(statusReason, ''), updatedAt FROM run WHERE id = $1", runID).Scan(&event.Status, &event.Reason, &event.Timestamp)
+	if err != nil {
+		logger.Error(fmt.Sprintf("RunStatus.db.QueryRow: %s", err.Error()))
+		return util.RunStatusEvent{}, fmt.Errorf("something went wrong")
+	}
+	return event, nil
+}
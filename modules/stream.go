@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/util"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// streamTokenPurpose separates stream tokens from other signed tokens.
+const streamTokenPurpose = "run-stream"
+
+// defaultStreamTokenTTL is how long a stream token is valid
+// unless STREAM_TOKEN_TTL is set.
+const defaultStreamTokenTTL = 5 * time.Minute
+
+type StreamTokenReq struct {
+	RunID string `json:"runID"`
+}
+
+func StreamTokenReqFromJSON(jsonData map[string]any) (*StreamTokenReq, error) {
+	s := &StreamTokenReq{}
+	jsonDataBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(jsonDataBytes, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func streamTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("STREAM_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultStreamTokenTTL
+}
+
+// StreamToken issues a short-lived token that lets EventSource clients,
+// which cannot send custom headers, stream the logs of a run the user can read.
+func (s *StreamTokenReq) StreamToken(ctx context.Context, user *User, logger *util.Logger) (string, time.Time, error) {
+	if _, err := RunAccessMode(ctx, s.RunID, user, logger); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(streamTokenTTL())
+	return util.Sign(streamTokenPurpose, user.ID+":"+s.RunID, expiresAt), expiresAt, nil
+}
+
+// VerifyStreamToken returns the user and run a stream token was issued for.
+func VerifyStreamToken(token string) (string, string, error) {
+	value, err := util.Verify(streamTokenPurpose, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	userID, runID, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", "", util.ErrInvalidToken
+	}
+	return userID, runID, nil
+}
+
+// AuthorizeRunStream checks that the request may read the run's logs, either
+// with the auth cookie or bearer token (see Auth) or with a stream token
+// passed as the token query parameter. It returns the HTTP status to
+// respond with if it may not.
+func AuthorizeRunStream(req *http.Request, runID string, logger *util.Logger) (int, error) {
+	if token := req.URL.Query().Get("token"); token != "" {
+		_, tokenRunID, err := VerifyStreamToken(token)
+		if err != nil {
+			return http.StatusUnauthorized, err
+		}
+		if tokenRunID != runID {
+			return http.StatusForbidden, fmt.Errorf("token was issued for another run")
+		}
+		// Tokens are only issued to users that can read the run.
+		return http.StatusOK, nil
+	}
+
+	user, err := Auth(req)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	if !user.HasScope(ScopeRunsRead) {
+		return http.StatusForbidden, fmt.Errorf("api key is missing the %s scope", ScopeRunsRead)
+	}
+	if err := Authorize(user, ActionRunRead); err != nil {
+		return http.StatusForbidden, err
+	}
+
+	_, err = RunAccessMode(req.Context(), runID, user, logger)
+	switch {
+	case errors.Is(err, ErrRunNotFound):
+		return http.StatusNotFound, err
+	case errors.Is(err, ErrRunAccessDenied):
+		return http.StatusForbidden, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
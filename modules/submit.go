@@ -0,0 +1,233 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/db/connection"
+	"evolve/storage"
+	"evolve/util"
+	"fmt"
+	"time"
+)
+
+// RunSpec is implemented by every algorithm spec that can be submitted as a run.
+type RunSpec interface {
+	// Code validates the spec and generates the Python script for the run.
+	Code() (string, error)
+	// RunName, RunDescription, RunType, RunCommand and RunSeed
+	// fill in the corresponding columns of the run table.
+	RunName() string
+	RunDescription() string
+	RunType() string
+	RunCommand() string
+	RunSeed() int64
+}
+
+// runParent is implemented by specs of runs created from another run.
+type runParent interface {
+	ParentRunID() string
+}
+
+// SpecError is returned by SubmitRun when the
+// spec itself is invalid and no run was created.
+type SpecError struct {
+	Err error
+}
+
+func (e *SpecError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SpecError) Unwrap() error {
+	return e.Err
+}
+
+// runArtifact is a file uploaded to minIO for a run.
+type runArtifact struct {
+	fileName  string
+	extension string
+	content   []byte
+}
+
+// SubmitRun creates a run for the spec on behalf of the user and queues it.
+// The spec is stored as the input of the run, so that it can be reproduced
+// with the same parameters and seed.
+//
+// The run and access rows are inserted in one transaction which is only
+// committed once the code and input have been uploaded. If anything fails
+// the rows are rolled back, uploaded objects are deleted and nothing is
+// queued, so a failed submission leaves no trace.
+func SubmitRun(ctx context.Context, spec RunSpec, userID string, logger *util.Logger) (string, error) {
+	code, err := spec.Code()
+	if err != nil {
+		return "", &SpecError{Err: err}
+	}
+
+	inputParams, err := json.Marshal(spec)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.json.Marshal: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.db.Begin: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+	// No-op once the transaction is committed.
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	var parentRunID *string
+	if parent, ok := spec.(runParent); ok {
+		id := parent.ParentRunID()
+		parentRunID = &id
+	}
+
+	var runID string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO run (name, description, type, command, seed, parentRunID, status, createdBy)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, spec.RunName(), spec.RunDescription(), spec.RunType(), spec.RunCommand(), spec.RunSeed(), parentRunID, RunStatusQueued, userID).Scan(&runID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.tx.QueryRow: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	logger.Info(fmt.Sprintf("RunID: %s", runID))
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO access (runID, userID, mode)
+		VALUES ($1, $2, $3)
+	`, runID, userID, "write")
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.tx.Exec: %s", err.Error()))
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	artifacts := []runArtifact{
+		{fileName: "code", extension: "py", content: []byte(code)},
+		{fileName: "input", extension: "json", content: inputParams},
+	}
+
+	var uploaded []runArtifact
+	for _, artifact := range artifacts {
+		if err := uploadRunArtifact(ctx, runID, artifact, logger); err != nil {
+			deleteRunArtifacts(ctx, runID, uploaded, logger)
+			return "", fmt.Errorf("something went wrong")
+		}
+		uploaded = append(uploaded, artifact)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.tx.Commit: %s", err.Error()))
+		deleteRunArtifacts(ctx, runID, uploaded, logger)
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	// Sent before the run is queued, so that it always comes before the
+	// status the runner reports next.
+	if err := util.AppendRunStatusEvent(ctx, util.RunStatusEvent{RunID: runID, Status: RunStatusQueued, Timestamp: time.Now()}); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun: failed to send status %s of run %s: %v", RunStatusQueued, runID, err))
+	}
+
+	// The run must be committed before a runner can pick it up,
+	// so a failure here has to be compensated for.
+	if err := util.EnqueueRunRequest(ctx, runID, "code", "py"); err != nil {
+		deleteRun(ctx, runID, logger)
+		deleteRunArtifacts(ctx, runID, uploaded, logger)
+		return "", fmt.Errorf("something went wrong")
+	}
+
+	return runID, nil
+}
+
+// uploadRunArtifact uploads the artifact straight from memory.
+func uploadRunArtifact(ctx context.Context, runID string, artifact runArtifact, logger *util.Logger) error {
+	objectName := storage.ObjectName(runID, artifact.fileName, artifact.extension)
+	if err := storage.PutBytes(ctx, objectName, artifact.content); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.storage.PutBytes: %s", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// deleteRunArtifacts removes uploaded artifacts of a failed submission.
+func deleteRunArtifacts(ctx context.Context, runID string, artifacts []runArtifact, logger *util.Logger) {
+	ctx = context.WithoutCancel(ctx)
+	for _, artifact := range artifacts {
+		if err := storage.Delete(ctx, storage.ObjectName(runID, artifact.fileName, artifact.extension)); err != nil {
+			logger.Error(fmt.Sprintf("SubmitRun: failed to delete %s.%s of run %s: %s", artifact.fileName, artifact.extension, runID, err.Error()))
+		}
+	}
+}
+
+// deleteRun removes the rows and status events of a committed
+// run that could not be queued.
+func deleteRun(ctx context.Context, runID string, logger *util.Logger) {
+	ctx = context.WithoutCancel(ctx)
+
+	if err := util.DeleteRunStatusEvents(ctx, runID); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun.util.DeleteRunStatusEvents: %s", err.Error()))
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun: %s", err.Error()))
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun.db.Begin: %s", err.Error()))
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM access WHERE runID = $1", runID); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun.tx.Exec: %s", err.Error()))
+		return
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM run WHERE id = $1", runID); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun.tx.Exec: %s", err.Error()))
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error(fmt.Sprintf("SubmitRun.deleteRun.tx.Commit: %s", err.Error()))
+	}
+}
+
+// IsSpecError reports whether err was caused by an invalid spec.
+func IsSpecError(err error) bool {
+	var specErr *SpecError
+	return errors.As(err, &specErr)
+}
+
+// withdrawRun undoes a successful submission, e.g. when a later run of
+// the same sweep could not be submitted. The run is taken off the queue
+// (or cancelled if a runner already picked it up) and deleted.
+func withdrawRun(ctx context.Context, runID string, logger *util.Logger) {
+	ctx = context.WithoutCancel(ctx)
+
+	dequeued, err := util.DequeueRunRequest(ctx, runID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("withdrawRun.util.DequeueRunRequest: %s", err.Error()))
+	} else if !dequeued {
+		if err := util.PublishRunCancel(ctx, runID); err != nil {
+			logger.Error(fmt.Sprintf("withdrawRun.util.PublishRunCancel: %s", err.Error()))
+		}
+	}
+
+	deleteRun(ctx, runID, logger)
+	deleteRunArtifacts(ctx, runID, []runArtifact{
+		{fileName: "code", extension: "py"},
+		{fileName: "input", extension: "json"},
+	}, logger)
+}
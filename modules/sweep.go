@@ -0,0 +1,406 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/db/connection"
+	"evolve/util"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// maxSweepRuns is the maximum number of runs a single sweep may expand to.
+const maxSweepRuns = 100
+
+// ErrSweepNotFound is returned when a sweep does not exist
+// or was not created by the user asking for it.
+var ErrSweepNotFound = errors.New("sweep does not exist")
+
+type (
+	// SweepReq creates one run per parameter set of either a grid or a
+	// random sample. Every parameter set overrides the top-level fields of
+	// the base spec, e.g. {"grid": {"cxpb": [0.5, 0.7], "mutpb": [0.1, 0.2]}}
+	// creates four runs.
+	SweepReq struct {
+		Name        string           `json:"name"`
+		Description string           `json:"description"`
+		Type        string           `json:"type"` // ea, gp, ml, pso or any other registered algorithm type.
+		Base        map[string]any   `json:"base"`
+		Grid        map[string][]any `json:"grid,omitempty"`
+		Random      *SweepSample     `json:"random,omitempty"`
+	}
+
+	// SweepSample draws Samples parameter sets at random.
+	// The seed is generated if not given and stored with the sweep.
+	SweepSample struct {
+		Samples    int                          `json:"samples"`
+		Seed       *uint64                      `json:"seed,omitempty"`
+		Parameters map[string]SweepDistribution `json:"parameters"`
+	}
+
+	// SweepDistribution is either a list of values to choose from or a
+	// uniform range [Min, Max], optionally restricted to integers.
+	SweepDistribution struct {
+		Values  []any    `json:"values,omitempty"`
+		Min     *float64 `json:"min,omitempty"`
+		Max     *float64 `json:"max,omitempty"`
+		Integer bool     `json:"integer,omitempty"`
+	}
+)
+
+// sweepRunSpec names a child run after its sweep.
+type sweepRunSpec struct {
+	Algorithm
+	name string
+}
+
+func (s sweepRunSpec) RunName() string {
+	return s.name
+}
+
+// MarshalJSON stores the algorithm spec as the input of the run.
+func (s sweepRunSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Algorithm)
+}
+
+func SweepReqFromJSON(jsonData map[string]any) (*SweepReq, error) {
+	s := &SweepReq{}
+	if err := decodeJSON(jsonData, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validate checks the sweep definition, but not the child specs.
+func (s *SweepReq) validate() error {
+	var errs util.ValidationErrors
+
+	errs.OneOf("type", s.Type, AlgorithmTypes())
+	if s.Base == nil {
+		errs.Add("base", "is required")
+	}
+
+	// A misspelled parameter would be ignored when decoding the
+	// child specs, so every run of the sweep would be the same.
+	fields := AlgorithmFields(s.Type)
+	checkField := func(field string, key string) {
+		if fields != nil && !slices.Contains(fields, key) {
+			errs.Add(field, "is not a parameter of %s specs", s.Type)
+		}
+	}
+
+	switch {
+	case len(s.Grid) == 0 && s.Random == nil:
+		errs.Add("grid", "either grid or random is required")
+	case len(s.Grid) > 0 && s.Random != nil:
+		errs.Add("random", "must not be combined with grid")
+	case s.Random != nil:
+		errs.Positive("random.samples", s.Random.Samples)
+		if s.Random.Samples > maxSweepRuns {
+			errs.Add("random.samples", "must not exceed %d, got %d", maxSweepRuns, s.Random.Samples)
+		}
+		if len(s.Random.Parameters) == 0 {
+			errs.Add("random.parameters", "must contain at least one parameter")
+		}
+		for _, key := range slices.Sorted(maps.Keys(s.Random.Parameters)) {
+			dist := s.Random.Parameters[key]
+			field := "random.parameters." + key
+			checkField(field, key)
+			switch {
+			case len(dist.Values) > 0 && (dist.Min != nil || dist.Max != nil):
+				errs.Add(field, "must have either values or min and max, not both")
+			case len(dist.Values) > 0:
+			case dist.Min == nil || dist.Max == nil:
+				errs.Add(field, "must have either values or min and max")
+			case *dist.Min > *dist.Max:
+				errs.Add(field, "min must not be greater than max, got %v > %v", *dist.Min, *dist.Max)
+			case dist.Integer && math.Ceil(*dist.Min) > math.Floor(*dist.Max):
+				errs.Add(field, "contains no integer between %v and %v", *dist.Min, *dist.Max)
+			}
+		}
+	default:
+		runs := 1
+		for _, key := range slices.Sorted(maps.Keys(s.Grid)) {
+			checkField("grid."+key, key)
+			if len(s.Grid[key]) == 0 {
+				errs.Add("grid."+key, "must contain at least one value")
+				continue
+			}
+			// Stop multiplying once over the limit to avoid overflowing.
+			if runs <= maxSweepRuns {
+				runs *= len(s.Grid[key])
+			}
+		}
+		if runs > maxSweepRuns {
+			errs.Add("grid", "must not expand to more than %d runs", maxSweepRuns)
+		}
+	}
+
+	return errs.Err()
+}
+
+// expand returns the parameter sets of the sweep in a stable order.
+// Grid sets vary the last parameter (in alphabetical order) fastest.
+func (s *SweepReq) expand() []map[string]any {
+	if s.Random != nil {
+		if s.Random.Seed == nil {
+			seed := rand.Uint64()
+			s.Random.Seed = &seed
+		}
+		rng := rand.New(rand.NewPCG(*s.Random.Seed, 0))
+		keys := slices.Sorted(maps.Keys(s.Random.Parameters))
+
+		sets := make([]map[string]any, s.Random.Samples)
+		for i := range sets {
+			sets[i] = map[string]any{}
+			for _, key := range keys {
+				sets[i][key] = s.Random.Parameters[key].sample(rng)
+			}
+		}
+		return sets
+	}
+
+	sets := []map[string]any{{}}
+	for _, key := range slices.Sorted(maps.Keys(s.Grid)) {
+		var next []map[string]any
+		for _, set := range sets {
+			for _, value := range s.Grid[key] {
+				params := maps.Clone(set)
+				params[key] = value
+				next = append(next, params)
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+func (d SweepDistribution) sample(rng *rand.Rand) any {
+	if len(d.Values) > 0 {
+		return d.Values[rng.IntN(len(d.Values))]
+	}
+	if d.Integer {
+		low, high := int(math.Ceil(*d.Min)), int(math.Floor(*d.Max))
+		return low + rng.IntN(high-low+1)
+	}
+	return *d.Min + rng.Float64()*(*d.Max-*d.Min)
+}
+
+// specs expands the sweep and checks every child spec, so that
+// nothing is submitted unless all of them are valid.
+func (s *SweepReq) specs() ([]map[string]any, []RunSpec, error) {
+	if err := s.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if s.Name == "" {
+		s.Name = fmt.Sprintf("%s sweep", s.Type)
+	}
+
+	sets := s.expand()
+	specs := make([]RunSpec, len(sets))
+
+	var errs util.ValidationErrors
+	for i, params := range sets {
+		input := maps.Clone(s.Base)
+		maps.Copy(input, params)
+
+		algo, err := AlgorithmFromJSON(s.Type, input)
+		if err == nil {
+			_, err = algo.Code()
+		}
+		if err != nil {
+			addSweepRunErrors(&errs, fmt.Sprintf("runs[%d]", i), err)
+			continue
+		}
+
+		specs[i] = sweepRunSpec{
+			Algorithm: algo,
+			name:      fmt.Sprintf("%s #%d", s.Name, i+1),
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return sets, specs, nil
+}
+
+// addSweepRunErrors reports the errors of a child spec under its position.
+func addSweepRunErrors(errs *util.ValidationErrors, prefix string, err error) {
+	var runErrs util.ValidationErrors
+	if !errors.As(err, &runErrs) {
+		errs.Add(prefix, "%s", err.Error())
+		return
+	}
+	for _, fieldErr := range runErrs {
+		errs.Add(prefix+"."+fieldErr.Field, "%s", fieldErr.Message)
+	}
+}
+
+// CreateSweep submits one run per parameter set and links them to a new
+// sweep. Either every run is submitted or none is: if a run cannot be
+// submitted, the runs submitted before it are withdrawn.
+func (s *SweepReq) CreateSweep(ctx context.Context, userID string, logger *util.Logger) (string, []string, error) {
+	sets, specs, err := s.specs()
+	if err != nil {
+		return "", nil, &SpecError{Err: err}
+	}
+
+	definition, err := json.Marshal(s)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CreateSweep.json.Marshal: %s", err.Error()))
+		return "", nil, fmt.Errorf("something went wrong")
+	}
+
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CreateSweep: %s", err.Error()))
+		return "", nil, fmt.Errorf("something went wrong")
+	}
+
+	var sweepID string
+	err = db.QueryRow(ctx, `
+		INSERT INTO sweep (name, description, type, definition, createdBy)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, s.Name, s.Description, s.Type, definition, userID).Scan(&sweepID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CreateSweep.db.QueryRow: %s", err.Error()))
+		return "", nil, fmt.Errorf("something went wrong")
+	}
+
+	logger.Info(fmt.Sprintf("SweepID: %s, runs: %d", sweepID, len(specs)))
+
+	var runIDs []string
+	abort := func() {
+		for _, runID := range runIDs {
+			withdrawRun(ctx, runID, logger)
+		}
+		if _, err := db.Exec(context.WithoutCancel(ctx), "DELETE FROM sweep WHERE id = $1", sweepID); err != nil {
+			logger.Error(fmt.Sprintf("CreateSweep.db.Exec: %s", err.Error()))
+		}
+	}
+
+	for i, spec := range specs {
+		runID, err := SubmitRun(ctx, spec, userID, logger)
+		if err != nil {
+			abort()
+			return "", nil, err
+		}
+		runIDs = append(runIDs, runID)
+
+		parameters, err := json.Marshal(sets[i])
+		if err == nil {
+			_, err = db.Exec(ctx, `
+				INSERT INTO sweepRun (sweepID, position, runID, parameters)
+				VALUES ($1, $2, $3, $4)
+			`, sweepID, i, runID, parameters)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("CreateSweep.db.Exec: %s", err.Error()))
+			abort()
+			return "", nil, fmt.Errorf("something went wrong")
+		}
+	}
+
+	return sweepID, runIDs, nil
+}
+
+// UserSweep returns a sweep together with the status of each of its runs
+// and a count of runs per status. The creator of the sweep and users whose
+// role allows ActionRunReadAny see every run, other users only see the runs
+// that were shared with them and get ErrSweepNotFound if there are none.
+func UserSweep(ctx context.Context, sweepID string, user *User, logger *util.Logger) (map[string]any, error) {
+	db, err := connection.PoolConn(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("UserSweep: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	readAny := Authorize(user, ActionRunReadAny) == nil
+
+	var name, description, sweepType, createdBy string
+	var definition map[string]any
+	var createdAt time.Time
+	err = db.QueryRow(ctx, `
+		SELECT name, description, type, definition, createdBy, createdAt
+		FROM sweep
+		WHERE id = $1 AND (
+			createdBy = $2 OR $3::BOOL OR EXISTS (
+				SELECT 1
+				FROM sweepRun s
+				JOIN access a ON a.runID = s.runID
+				WHERE s.sweepID = sweep.id AND a.userID = $2
+			)
+		)
+	`, sweepID, user.ID, readAny).Scan(&name, &description, &sweepType, &definition, &createdBy, &createdAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("UserSweep.db.QueryRow: %s", err.Error()))
+		return nil, ErrSweepNotFound
+	}
+
+	allRuns := readAny || createdBy == user.ID
+	rows, err := db.Query(ctx, `
+		SELECT s.position, s.runID, s.parameters, r.name, r.status, COALESCE(r.statusReason, ''), r.seed, r.updatedAt
+		FROM sweepRun s
+		JOIN run r ON r.id = s.runID
+		WHERE s.sweepID = $1 AND (
+			$2::BOOL OR EXISTS (SELECT 1 FROM access a WHERE a.runID = s.runID AND a.userID = $3)
+		)
+		ORDER BY s.position
+	`, sweepID, allRuns, user.ID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("UserSweep.db.Query: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+	defer rows.Close()
+
+	runs := []map[string]any{}
+	statusCounts := map[string]int{}
+	for rows.Next() {
+		var position int
+		var runID, runName, status, statusReason string
+		var parameters map[string]any
+		var seed *int64
+		var updatedAt time.Time
+
+		if err := rows.Scan(&position, &runID, &parameters, &runName, &status, &statusReason, &seed, &updatedAt); err != nil {
+			logger.Error(fmt.Sprintf("UserSweep.rows.Scan: %s", err.Error()))
+			return nil, fmt.Errorf("something went wrong")
+		}
+
+		runs = append(runs, map[string]any{
+			"position":     position,
+			"runID":        runID,
+			"name":         runName,
+			"parameters":   parameters,
+			"status":       status,
+			"statusReason": statusReason,
+			"seed":         seed,
+			"updatedAt":    updatedAt.Local().String(),
+		})
+		statusCounts[status]++
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("UserSweep.rows.Err: %s", err.Error()))
+		return nil, fmt.Errorf("something went wrong")
+	}
+
+	return map[string]any{
+		"id":           sweepID,
+		"name":         name,
+		"description":  description,
+		"type":         sweepType,
+		"definition":   definition,
+		"createdBy":    createdBy,
+		"createdAt":    createdAt.Local().String(),
+		"runs":         runs,
+		"statusCounts": statusCounts,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package modules
+
+import (
+	"errors"
+	"evolve/util"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestSweepExpandGrid(t *testing.T) {
+	tests := []struct {
+		name string
+		grid map[string][]any
+		want int
+	}{
+		{"single parameter", map[string][]any{"cxpb": {0.5, 0.7, 0.9}}, 3},
+		{"two parameters", map[string][]any{"cxpb": {0.5, 0.7}, "mutpb": {0.1, 0.2}}, 4},
+		{"three parameters", map[string][]any{"cxpb": {0.5, 0.7}, "mutpb": {0.1, 0.2}, "populationSize": {10, 20, 30}}, 12},
+		{"single value", map[string][]any{"cxpb": {0.5}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SweepReq{Type: "ea", Base: map[string]any{}, Grid: tt.grid}
+			if err := s.validate(); err != nil {
+				t.Fatalf("validate() = %v", err)
+			}
+
+			sets := s.expand()
+			if len(sets) != tt.want {
+				t.Fatalf("expand() returned %d sets, want %d", len(sets), tt.want)
+			}
+			seen := map[string]bool{}
+			for _, set := range sets {
+				if len(set) != len(tt.grid) {
+					t.Errorf("set %v does not set every grid parameter", set)
+				}
+				seen[fmt.Sprint(set)] = true
+			}
+			if len(seen) != tt.want {
+				t.Errorf("expand() returned duplicate sets: %v", sets)
+			}
+		})
+	}
+}
+
+func TestSweepExpandRandom(t *testing.T) {
+	seed := uint64(42)
+	s := &SweepReq{
+		Type: "ea",
+		Base: map[string]any{},
+		Random: &SweepSample{
+			Samples: 50,
+			Seed:    &seed,
+			Parameters: map[string]SweepDistribution{
+				"cxpb":              {Min: floatPtr(0.2), Max: floatPtr(0.4)},
+				"populationSize":    {Min: floatPtr(9.5), Max: floatPtr(12.5), Integer: true},
+				"selectionFunction": {Values: []any{"selBest", "selRandom"}},
+			},
+		},
+	}
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+
+	sets := s.expand()
+	if len(sets) != 50 {
+		t.Fatalf("expand() returned %d sets, want 50", len(sets))
+	}
+	for _, set := range sets {
+		if cxpb := set["cxpb"].(float64); cxpb < 0.2 || cxpb > 0.4 {
+			t.Errorf("cxpb = %v, want within [0.2, 0.4]", cxpb)
+		}
+		if size := set["populationSize"].(int); size < 10 || size > 12 {
+			t.Errorf("populationSize = %v, want an integer within [10, 12]", size)
+		}
+		if fn := set["selectionFunction"]; fn != "selBest" && fn != "selRandom" {
+			t.Errorf("selectionFunction = %v, want one of the values", fn)
+		}
+	}
+
+	// The same seed draws the same sets.
+	again := s.expand()
+	for i := range sets {
+		if fmt.Sprint(sets[i]) != fmt.Sprint(again[i]) {
+			t.Fatalf("expand() with seed %d is not reproducible: %v != %v", seed, sets[i], again[i])
+		}
+	}
+}
+
+func TestSweepValidate(t *testing.T) {
+	grid := func(values int) []any {
+		v := make([]any, values)
+		for i := range v {
+			v[i] = i + 1
+		}
+		return v
+	}
+
+	tests := []struct {
+		name   string
+		req    SweepReq
+		fields []string // Fields expected to be reported, none if valid.
+	}{
+		{
+			name: "grid at the limit",
+			req:  SweepReq{Type: "ea", Base: map[string]any{}, Grid: map[string][]any{"populationSize": grid(10), "generations": grid(10)}},
+		},
+		{
+			name:   "grid over the limit",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Grid: map[string][]any{"populationSize": grid(10), "generations": grid(11)}},
+			fields: []string{"grid"},
+		},
+		{
+			name:   "too many samples",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Random: &SweepSample{Samples: maxSweepRuns + 1, Parameters: map[string]SweepDistribution{"cxpb": {Values: []any{0.5}}}}},
+			fields: []string{"random.samples"},
+		},
+		{
+			name:   "unknown grid parameter",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Grid: map[string][]any{"cxbp": {0.5, 0.7}}},
+			fields: []string{"grid.cxbp"},
+		},
+		{
+			name:   "unknown random parameter",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Random: &SweepSample{Samples: 2, Parameters: map[string]SweepDistribution{"mutbp": {Min: floatPtr(0), Max: floatPtr(1)}}}},
+			fields: []string{"random.parameters.mutbp"},
+		},
+		{
+			name: "embedded parameter",
+			req:  SweepReq{Type: "ea", Base: map[string]any{}, Grid: map[string][]any{"seed": {1, 2}}},
+		},
+		{
+			name:   "empty range",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Random: &SweepSample{Samples: 2, Parameters: map[string]SweepDistribution{"populationSize": {Min: floatPtr(10.2), Max: floatPtr(10.8), Integer: true}}}},
+			fields: []string{"random.parameters.populationSize"},
+		},
+		{
+			name:   "grid and random",
+			req:    SweepReq{Type: "ea", Base: map[string]any{}, Grid: map[string][]any{"cxpb": {0.5}}, Random: &SweepSample{Samples: 1}},
+			fields: []string{"random"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var errs util.ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation errors, got %v", err)
+			}
+			var fields []string
+			for _, fieldErr := range errs {
+				fields = append(fields, fieldErr.Field)
+			}
+			for _, field := range tt.fields {
+				if !slices.Contains(fields, field) {
+					t.Errorf("expected an error for %s, got %v", field, errs)
+				}
+			}
+		})
+	}
+}
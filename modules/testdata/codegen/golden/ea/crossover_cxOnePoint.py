@@ -0,0 +1,96 @@
+import random, os
+from deap import base, creator, tools, algorithms, cma
+import numpy
+import matplotlib.pyplot as plt
+from functools import reduce
+from scoop import futures
+from deap import benchmarks
+from itertools import chain
+
+
+
+
+
+
+
+toolbox = base.Toolbox()
+
+creator.create('FitnessMax', base.Fitness, weights=(-1.000000,))
+creator.create("Individual", list, fitness=creator.FitnessMax)
+
+toolbox.register("attr", random.uniform, -5.000000, 5.000000)
+
+toolbox.register("individual", tools.initRepeat, creator.Individual, toolbox.attr, 10)
+toolbox.register("population", tools.initRepeat, list, toolbox.individual)
+
+toolbox.register("evaluate", benchmarks.rastrigin)
+toolbox.register("mutate", tools.mutFlipBit, indpb=0.050000)
+
+toolbox.register("mate", tools.cxOnePoint)
+
+toolbox.register("select", tools.selTournament, tournsize=3)
+
+
+toolbox.register("map", futures.map)
+
+def main():
+	random.seed(318)
+	numpy.random.seed(318)
+	populationSize = 100
+	generations = 50
+	cxpb = 0.500000
+	mutpb = 0.200000
+	N = 10
+
+	pop = toolbox.population(n=populationSize)
+	hof = tools.HallOfFame(1)
+
+	stats = tools.Statistics(lambda ind: ind.fitness.values)
+	stats.register("avg", numpy.mean)
+	stats.register("min", numpy.min)
+	stats.register("max", numpy.max)
+
+	pop, logbook = algorithms.eaSimple(pop, toolbox, cxpb=cxpb, mutpb=mutpb, ngen=generations, stats=stats, halloffame=hof, verbose=True)
+
+
+	rootPath = os.path.dirname(os.path.abspath(__file__))
+	with open(f"{rootPath}/logbook.txt", "w") as f:
+		f.write(str(logbook))
+
+	out_file = open(f"{rootPath}/best.txt", "w")
+	out_file.write(f"Best individual fitness: {hof[0].fitness.values}\n")
+
+	out_file.write(f"Best individual: {hof[0]}\n")
+	out_file.close()
+
+
+
+
+	gen = logbook.select("gen")
+	avg = logbook.select("avg")
+	min_ = logbook.select("min")
+	max_ = logbook.select("max")
+
+	plt.plot(gen, avg, label="average")
+	plt.plot(gen, min_, label="minimum")
+	plt.plot(gen, max_, label="maximum")
+	plt.xlabel("Generation")
+	plt.ylabel("Fitness")
+	plt.legend(loc="lower right")
+	plt.savefig(f"{rootPath}/fitness_plot.png", dpi=300)
+	plt.close()
+
+
+	avg_fitness = logbook.select("avg")
+	fitness_diff = [avg_fitness[i] - avg_fitness[i-1] for i in range(1, len(avg_fitness))]
+	plt.plot(gen[1:], fitness_diff, label="Fitness Change", color="purple")
+	plt.xlabel("Generation")
+	plt.ylabel("Fitness Change")
+	plt.title("Effect of Mutation and Crossover on Fitness")
+	plt.legend()
+	plt.savefig(f"{rootPath}/mutation_crossover_effect.png", dpi=300)
+	plt.close()
+
+
+if __name__ == '__main__':
+	main()
\ No newline at end of file
@@ -0,0 +1,157 @@
+import random, os
+from deap import base, creator, tools, algorithms, cma
+import numpy
+import matplotlib.pyplot as plt
+from functools import reduce
+from scoop import futures
+from deap import benchmarks
+from itertools import chain
+
+
+
+def mutDE(y, a, b, c, f):
+	size = len(y)
+	for i in range(len(y)):
+		y[i] = a[i] + f*(b[i]-c[i])
+	return y
+
+def mutDE_current_to_best1(y, x, best, b, c, f):
+	size = len(y)
+	for i in range(size):
+		y[i] = x[i] + f * (best[i] - x[i]) + f * (b[i] - c[i])
+	return y
+
+def cxBinomial(x, y, cr):
+	size = len(x)
+	index = random.randrange(size)
+	for i in range(size):
+		if i == index or random.random() < cr:
+			x[i] = y[i]
+	return x
+
+
+def cxExponential(x, y, cr):
+	size = len(x)
+	index = random.randrange(size)
+	for i in chain(range(index, size), range(0, index)):
+		x[i] = y[i]
+		if random.random() < cr:
+			break
+	return x
+
+
+
+
+
+toolbox = base.Toolbox()
+
+creator.create('FitnessMax', base.Fitness, weights=(-1.000000,))
+creator.create("Individual", list, fitness=creator.FitnessMax)
+
+toolbox.register("attr", random.uniform, -5.000000, 5.000000)
+
+toolbox.register("individual", tools.initRepeat, creator.Individual, toolbox.attr, 10)
+toolbox.register("population", tools.initRepeat, list, toolbox.individual)
+
+toolbox.register("evaluate", benchmarks.rastrigin)
+toolbox.register("mutate", mutDE, f=1.000000)
+
+CR = 0.250000
+F = 1.000000
+toolbox.register("mate", cxBinomial, cr=CR)
+toolbox.register("select", tools.selRandom)
+
+
+toolbox.register("map", futures.map)
+
+def main():
+	random.seed(318)
+	numpy.random.seed(318)
+	populationSize = 100
+	generations = 50
+	cxpb = 0.500000
+	mutpb = 0.200000
+	N = 10
+
+	pop = toolbox.population(n=populationSize)
+	hof = tools.HallOfFame(1)
+
+	stats = tools.Statistics(lambda ind: ind.fitness.values)
+	stats.register("avg", numpy.mean)
+	stats.register("min", numpy.min)
+	stats.register("max", numpy.max)
+
+
+
+	logbook = tools.Logbook()
+	logbook.header = 'gen', 'evals', 'std', 'min', 'avg', 'max'
+	fitnesses = toolbox.map(toolbox.evaluate, pop)
+	for ind, fit in zip(pop, fitnesses):
+		ind.fitness.values = fit
+	record = stats.compile(pop)
+	logbook.record(gen=0, evals=len(pop), **record)
+	print(logbook.stream)
+	for g in range(1, generations):
+		children = []
+		for agent in pop:
+			a, b, c = [toolbox.clone(ind) for ind in toolbox.select(pop, 3)]
+			x = toolbox.clone(agent)
+			y = toolbox.clone(agent)
+			y = toolbox.mutate(y, a, b, c)
+			z = toolbox.mate(x, y)
+			del z.fitness.values
+			children.append(z)
+	
+
+		fitnesses = toolbox.map(toolbox.evaluate, children)
+		for (i, ind), fit in zip(enumerate(children), fitnesses):
+			ind.fitness.values = fit
+			if ind.fitness > pop[i].fitness:
+				pop[i] = ind
+	
+
+		hof.update(pop)
+		record = stats.compile(pop)
+		logbook.record(gen=g, evals=len(pop), **record)
+		print(logbook.stream)
+	rootPath = os.path.dirname(os.path.abspath(__file__))
+	with open(f"{rootPath}/logbook.txt", "w") as f:
+		f.write(str(logbook))
+
+	out_file = open(f"{rootPath}/best.txt", "w")
+	out_file.write(f"Best individual fitness: {hof[0].fitness.values}\n")
+
+	out_file.write(f"Best individual: {hof[0]}\n")
+	out_file.close()
+
+
+
+
+	gen = logbook.select("gen")
+	avg = logbook.select("avg")
+	min_ = logbook.select("min")
+	max_ = logbook.select("max")
+
+	plt.plot(gen, avg, label="average")
+	plt.plot(gen, min_, label="minimum")
+	plt.plot(gen, max_, label="maximum")
+	plt.xlabel("Generation")
+	plt.ylabel("Fitness")
+	plt.legend(loc="lower right")
+	plt.savefig(f"{rootPath}/fitness_plot.png", dpi=300)
+	plt.close()
+
+
+	avg_fitness = logbook.select("avg")
+	fitness_diff = [avg_fitness[i] - avg_fitness[i-1] for i in range(1, len(avg_fitness))]
+	plt.plot(gen[1:], fitness_diff, label="Fitness Change", color="purple")
+	plt.xlabel("Generation")
+	plt.ylabel("Fitness Change")
+	plt.title("Effect of Mutation and Crossover on Fitness")
+	plt.legend()
+	plt.savefig(f"{rootPath}/mutation_crossover_effect.png", dpi=300)
+	plt.close()
+
+
+if __name__ == '__main__':
+	main()
\ No newline at end of file
@@ -0,0 +1,115 @@
+import operator
+import math
+import random
+import numpy
+import os
+import matplotlib.pyplot as plt
+import networkx as nx
+from functools import partial
+from deap import algorithms, base, creator, tools, gp, cma
+from scoop import futures
+
+def evalSymbReg(individual, points, realFunction):
+	# Transform the tree expression in a callable function
+	func = toolbox.compile(expr=individual)
+	# Evaluate the mean squared error between the expression
+	# and the real function : x**4 + x**3 + x**2 + x
+	sqerrors= ((func(x) - eval(realFunction))**2 for x in points)
+	return (math.fsum(sqerrors) / len(points),)
+
+
+
+toolbox = base.Toolbox()
+pset = gp.PrimitiveSet('MAIN', 1)
+
+def protectedDiv(left, right):
+	try:
+		return left / right
+	except ZeroDivisionError:
+		return 1
+
+def lf(x):
+	return 1 / (1 + numpy.exp(-x))
+
+pset.addPrimitive(operator.add, 2)
+pset.addPrimitive(operator.sub, 2)
+pset.addPrimitive(operator.mul, 2)
+pset.addPrimitive(protectedDiv, 2)
+pset.addPrimitive(operator.neg, 1)
+pset.addPrimitive(math.cos, 1)
+pset.addPrimitive(math.sin, 1)
+
+
+pset.addEphemeralConstant('rand101', partial(random.randint, -1, 1))
+arg_dict = {'ARG0': 'x'}
+pset.renameArguments(**arg_dict)
+
+
+creator.create('Fitness', base.Fitness, weights=(-1.000000,))
+creator.create('Individual', gp.PrimitiveTree, fitness=creator.Fitness)
+
+toolbox.register('expr', gp.genHalfAndHalf, pset=pset, min_=1, max_=2)
+toolbox.register('individual', tools.initIterate, creator.Individual, toolbox.expr)
+toolbox.register('population', tools.initRepeat, list, toolbox.individual)
+toolbox.register('compile', gp.compile, pset=pset)
+
+toolbox.register('evaluate', evalSymbReg, points=[x / 10.0 for x in range(-10, 10)], realFunction="x**4 + x**3 + x**2 + x")
+
+toolbox.register('select', tools.selTournament, tournsize=3)
+
+toolbox.register('mate', gp.cxOnePoint)
+
+toolbox.register('expr_mut', gp.genFull, min_=0, max_=2)
+toolbox.register('mutate', gp.mutUniform, expr=toolbox.expr_mut, pset=pset)
+
+toolbox.decorate('mate', gp.staticLimit(key=operator.attrgetter('height'), max_value=17))
+toolbox.decorate('mutate', gp.staticLimit(key=operator.attrgetter('height'), max_value=17))
+
+toolbox.register('map', futures.map)
+def main():
+	rootPath = os.path.dirname(os.path.abspath(__file__))
+	random.seed(318)
+	numpy.random.seed(318)
+	pop = toolbox.population(n=300)
+	hof = tools.HallOfFame(1)
+	stats_fit = tools.Statistics(lambda ind: ind.fitness.values)
+	stats_size = tools.Statistics(len)
+	mstats = tools.MultiStatistics(fitness=stats_fit, size=stats_size)
+	mstats.register('avg', numpy.mean)
+	mstats.register('std', numpy.std)
+	mstats.register('min', numpy.min)
+	mstats.register('max', numpy.max)
+
+	N = 10
+	strategy = cma.Strategy(centroid=[5.0] * 10, sigma=5.0, lambda_=20 * 10)
+	toolbox.register('generate', strategy.generate, creator.Individual)
+	toolbox.register('update', strategy.update)
+	pop, logbook = algorithms.eaGenerateUpdate(toolbox, ngen=40, stats=mstats, halloffame=hof, verbose=True)
+
+	with open(f"{rootPath}/logbook.txt", "w") as f:
+		f.write(str(logbook))
+
+	out_file = open(f"{rootPath}/best.txt", "w")
+	out_file.write(f"Best individual fitness: {hof[0].fitness.values}\n")
+	out_file.close()
+
+
+	expr = hof[0]
+	nodes, edges, labels = gp.graph(expr)
+	g = nx.Graph()
+	g.add_nodes_from(nodes)
+	g.add_edges_from(edges)
+	pos = nx.nx_agraph.graphviz_layout(g, prog='dot')
+
+	plt.figure(figsize=(7,7))
+	nx.draw_networkx_nodes(g, pos, node_size=900, node_color='skyblue')
+	nx.draw_networkx_edges(g, pos, edge_color='gray')
+	nx.draw_networkx_labels(g, pos, labels, font_color='black')
+	plt.axis('off')
+	plt.savefig(f'{rootPath}/graph.png', dpi=300)
+	plt.close()
+
+
+
+if __name__ == '__main__':
+	main()
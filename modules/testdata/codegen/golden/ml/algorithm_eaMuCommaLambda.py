@@ -0,0 +1,100 @@
+# DEAP imports
+import random, os
+from deap import base, creator, tools, algorithms, cma
+import numpy
+import matplotlib.pyplot as plt
+from functools import reduce
+from scoop import futures
+import pandas as pd
+import warnings
+warnings.filterwarnings("ignore")
+# ML imports
+from sklearn.model_selection import train_test_split
+from sklearn.linear_model import LogisticRegression
+from sklearn.metrics import accuracy_score
+def download_csv_from_google_drive_share_link(url):
+	file_id = url.split("/")[-2]
+	dwn_url = "https://drive.google.com/uc?export=download&id=" + file_id
+	return pd.read_csv(dwn_url, sep=",")
+
+def mlEvalFunction(individual, X, y):
+	columns = [c for c, keep in zip(X.columns, individual) if keep]
+	if not columns:
+		return 0,
+	X_train, X_test, y_train, y_test = train_test_split(X[columns], y, test_size=0.2, random_state=42)
+	model = LogisticRegression(max_iter=1000)
+	model.fit(X_train, y_train)
+	return accuracy_score(y_test, model.predict(X_test)),
+
+toolbox = base.Toolbox()
+
+toolbox.register("mate", tools.cxOnePoint)
+toolbox.register("mutate", tools.mutFlipBit, indpb=0.05)
+toolbox.register('select', tools.selTournament, tournsize=3)
+
+
+toolbox.register("map", futures.map)
+
+def main():
+	rootPath = os.path.dirname(os.path.abspath(__file__))
+	random.seed(318)
+	numpy.random.seed(318)
+	url = "https://drive.google.com/file/d/1a2b3c4d5e6f/view?usp=sharing"
+	df = download_csv_from_google_drive_share_link(url)
+	target = "target"
+	X = df.drop(target, axis=1)
+	y = df[target]
+	accuracy = mlEvalFunction([1 for _ in range(len(X.columns))], X, y)
+	creator.create("FitnessMax", base.Fitness, weights=(1.000000,))
+	creator.create("Individual", list, fitness=creator.FitnessMax)
+	toolbox.register("attr", random.randint, 0, 1)
+	toolbox.register("individual", tools.initRepeat, creator.Individual, toolbox.attr, n=len(X.columns))
+	toolbox.register("population", tools.initRepeat, list, toolbox.individual)
+	toolbox.register("evaluate", mlEvalFunction, X=X, y=y)
+	populationSize = 50
+	generations = 20
+	cxpb = 0.5
+	mutpb = 0.2
+	N = len(X.columns)
+	hofSize = 1
+
+	pop = toolbox.population(n=populationSize)
+	hof = tools.HallOfFame(hofSize)
+
+	stats = tools.Statistics(lambda ind: ind.fitness.values)
+	stats.register("avg", numpy.mean)
+	stats.register("min", numpy.min)
+	stats.register("max", numpy.max)
+	mu = 50
+	lambda_ = 100
+	pop, logbook = algorithms.eaMuCommaLambda(pop, toolbox, mu=mu, lambda_=lambda_, cxpb=cxpb, mutpb=mutpb, ngen=generations, stats=stats, halloffame=hof, verbose=True)
+	out_file = open(f"{rootPath}/best.txt", "w")
+	out_file.write(f"Before applying EA: {accuracy}\n")
+	out_file.write(f"Best individual is:\n{hof[0]}\nwith fitness: {hof[0].fitness}\n")
+	best_columns = [i for i in range(len(hof[0])) if hof[0][i] == 1]
+	best_column_names = X.columns[best_columns]
+	out_file.write(f"\nBest individual columns:\n{best_column_names.values}")
+	out_file.close()
+
+
+
+	gen = logbook.select("gen")
+	avg = logbook.select("avg")
+	min_ = logbook.select("min")
+	max_ = logbook.select("max")
+
+	with open(f"{rootPath}/logbook.txt", "w") as f:
+		f.write(str(logbook))
+
+
+	plt.plot(gen, avg, label="average")
+	plt.plot(gen, min_, label="minimum")
+	plt.plot(gen, max_, label="maximum")
+	plt.xlabel("Generation")
+	plt.ylabel("Fitness")
+	plt.legend(loc="lower right")
+	plt.savefig(f"{rootPath}/fitness_plot.png", dpi=300)
+	plt.close()
+
+if __name__ == '__main__':
+	main()
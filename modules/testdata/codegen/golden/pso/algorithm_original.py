@@ -0,0 +1,129 @@
+import math, os, random
+import numpy
+from deap import base, benchmarks, creator, tools
+import matplotlib.pyplot as plt
+import matplotlib.animation as animation
+
+creator.create('FitnessMax', base.Fitness, weights=(1.000000,))
+creator.create('Particle', numpy.ndarray, fitness=creator.FitnessMax, speed=list, smin=None, smax=None, best=None)
+
+def generate(size, pmin, pmax, smin, smax):
+	part = creator.Particle(numpy.random.uniform(pmin, pmax, size))
+	part.speed = numpy.random.uniform(smin, smax, size)
+	part.smin = smin
+	part.smax = smax
+	return part
+
+def updateParticle(part, best, phi1, phi2):
+	u1 = numpy.random.uniform(0, phi1, len(part))
+	u2 = numpy.random.uniform(0, phi2, len(part))
+	v_u1 = u1 * (part.best - part)
+	v_u2 = u2 * (best - part)
+	part.speed += v_u1 + v_u2
+	for i, speed in enumerate(part.speed):
+		if abs(speed) < part.smin:
+			part.speed[i] = math.copysign(part.smin, speed)
+		elif abs(speed) > part.smax:
+			part.speed[i] = math.copysign(part.smax, speed)
+	part += part.speed
+toolbox = base.Toolbox()
+toolbox.register('particle', generate, size=2, pmin=-6.000000, pmax=6.000000, smin=-3.000000, smax=3.000000)
+toolbox.register('population', tools.initRepeat, list, toolbox.particle)
+toolbox.register('update', updateParticle, phi1=2.000000, phi2=2.000000)
+toolbox.register('evaluate', benchmarks.h1)
+def main():
+	rootPath = os.path.dirname(os.path.abspath(__file__))
+	random.seed(318)
+	numpy.random.seed(318)
+	pop = toolbox.population(n=5)
+	stats = tools.Statistics(lambda ind: ind.fitness.values)
+	stats.register('avg', numpy.mean)
+	stats.register('std', numpy.std)
+	stats.register('min', numpy.min)
+	stats.register('max', numpy.max)
+
+	logbook = tools.Logbook()
+	logbook.header = ['gen', 'evals'] + stats.fields
+
+	best = None
+	GEN = 1000
+	fig, ax = plt.subplots()
+	# Dynamically determine plot space based on initial particle positions.
+	all_x = [p[0] for p in pop]
+	all_y = [p[1] for p in pop]
+	x_min = min(all_x)
+	x_max = max(all_x)
+	y_min = min(all_y)
+	y_max = max(all_y)
+	# Add a buffer to the plot limits to ensure particles don't get cut off
+	x_buffer = (x_max - x_min) * 0.5
+	y_buffer = (y_max - y_min) * 0.5
+	x_min -= x_buffer
+	x_max += x_buffer
+	y_min -= y_buffer
+	y_max += y_buffer
+	ax.set_xlim(x_min, x_max)
+	ax.set_ylim(y_min, y_max)
+	scat = ax.scatter([p[0] for p in pop], [p[1] for p in pop])  # Initial scatter plot
+	best_scat = ax.scatter([], [], color='red', marker='*', s=100) # Scatter plot for the best particle
+	plt.xlabel('x')
+	plt.ylabel('y')
+	plt.title('Particle Swarm Optimization')
+	generation_text = ax.text(0.02, 0.95, '', transform=ax.transAxes)  # Text to display generation
+	def update(frame):
+		nonlocal best, pop, x_min, x_max, y_min, y_max  # Access the pop variable and plot limits
+		for part in pop:
+			part.fitness.values = toolbox.evaluate(part)
+			if part.best is None or part.best.fitness < part.fitness:
+				part.best = creator.Particle(part)
+				part.best.fitness.values = part.fitness.values
+			if best is None or best.fitness < part.fitness:
+				best = creator.Particle(part)
+				best.fitness.values = part.fitness.values
+		for part in pop:
+			toolbox.update(part, best)
+		# Update scatter plot positions
+		scat.set_offsets(numpy.array([[p[0], p[1]] for p in pop]))
+		# Update best particle position
+		best_scat.set_offsets(numpy.array([[best[0], best[1]]]))
+		# Update generation text
+		generation_text.set_text(f'Generation: {frame}')
+		# Dynamically adjust the plot limits based on particle positions
+		all_x = [p[0] for p in pop]
+		all_y = [p[1] for p in pop]
+		curr_x_min = min(all_x)
+		curr_x_max = max(all_x)
+		curr_y_min = min(all_y)
+		curr_y_max = max(all_y)
+		x_buffer = (curr_x_max - curr_x_min) * 0.5
+		y_buffer = (curr_y_max - curr_y_min) * 0.5
+		curr_x_min -= x_buffer
+		curr_x_max += x_buffer
+		curr_y_min -= y_buffer
+		curr_y_max += y_buffer
+		# Expand the plot space if particles are going out of range, but never shrink it.
+		if curr_x_min < x_min:
+			x_min = curr_x_min
+		if curr_x_max > x_max:
+			x_max = curr_x_max
+		if curr_y_min < y_min:
+			y_min = curr_y_min
+		if curr_y_max > y_max:
+			y_max = curr_y_max
+		ax.set_xlim(x_min, x_max)
+		ax.set_ylim(y_min, y_max)
+		# Gather all the fitnesses in one list and print the stats
+		logbook.record(gen=frame, evals=len(pop), **stats.compile(pop))
+		print(logbook.stream)
+		return scat, best_scat, generation_text
+	ani = animation.FuncAnimation(fig, update, frames=GEN, blit=True, repeat=False)
+	ani.save(f'{rootPath}/pso_animation.gif', writer='pillow', fps=10)
+	# Save the position of the best particle
+	out_file = open(f'{rootPath}/best.txt', 'w')
+	out_file.write(f'Best individual fitness: {best.fitness.values}\n')
+	out_file.write(f'Best individual position: {best}\n')
+	out_file.close()
+	with open(f'{rootPath}/logbook.txt', 'w') as f:
+		f.write(str(logbook))
+if __name__ == '__main__':
+	main()
\ No newline at end of file
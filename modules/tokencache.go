@@ -0,0 +1,173 @@
+package modules
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"evolve/util"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenCacheConfig bounds the cache of auth results.
+type tokenCacheConfig struct {
+	size        int           // Most tokens kept, the least recently used are evicted first.
+	ttl         time.Duration // How long a valid token is trusted without asking the auth service.
+	negativeTTL time.Duration // How long an invalid token is rejected without asking the auth service.
+	apiKeyTTL   time.Duration // How long a valid API key is trusted, and so still accepted after being revoked.
+}
+
+func tokenCacheConfigFromEnv(logger *util.Logger) tokenCacheConfig {
+	config := tokenCacheConfig{
+		size:        10000,
+		ttl:         time.Minute,
+		negativeTTL: 10 * time.Second,
+		apiKeyTTL:   10 * time.Second,
+	}
+
+	if value := os.Getenv("AUTH_CACHE_SIZE"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			config.size = n
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid AUTH_CACHE_SIZE %q, using default: %d", value, config.size))
+		}
+	}
+	if value := os.Getenv("AUTH_CACHE_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.ttl = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid AUTH_CACHE_TTL %q, using default: %s", value, config.ttl))
+		}
+	}
+	if value := os.Getenv("AUTH_CACHE_NEGATIVE_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.negativeTTL = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid AUTH_CACHE_NEGATIVE_TTL %q, using default: %s", value, config.negativeTTL))
+		}
+	}
+	if value := os.Getenv("AUTH_CACHE_API_KEY_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.apiKeyTTL = d
+		} else {
+			logger.Warn(fmt.Sprintf("Invalid AUTH_CACHE_API_KEY_TTL %q, using default: %s", value, config.apiKeyTTL))
+		}
+	}
+	return config
+}
+
+type tokenCacheEntry struct {
+	key       [sha256.Size]byte
+	user      User
+	valid     bool
+	expiresAt time.Time
+}
+
+// tokenCache is a bounded LRU cache of token to user, which also
+// remembers invalid tokens for a shorter time. Tokens are only kept
+// as hashes.
+type tokenCache struct {
+	config  tokenCacheConfig
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // Most recently used first.
+	now     func() time.Time
+}
+
+func newTokenCache(config tokenCacheConfig) *tokenCache {
+	return &tokenCache{
+		config:  config,
+		entries: map[[sha256.Size]byte]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached result for the token and whether there is one.
+func (c *tokenCache) get(token string) (*User, bool, bool) {
+	if c == nil {
+		return nil, false, false
+	}
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	entry := element.Value.(*tokenCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false, false
+	}
+
+	c.order.MoveToFront(element)
+	if !entry.valid {
+		return nil, false, true
+	}
+	user := entry.user
+	user.Scopes = slices.Clone(user.Scopes)
+	return &user, true, true
+}
+
+// put caches the result of validating the token.
+func (c *tokenCache) put(token string, user *User, valid bool) {
+	if c == nil {
+		return
+	}
+	ttl := c.config.ttl
+	if !valid {
+		ttl = c.config.negativeTTL
+	} else if user != nil && user.APIKeyID != "" {
+		ttl = min(ttl, c.config.apiKeyTTL)
+	}
+	if c.config.size == 0 || ttl == 0 {
+		return
+	}
+
+	key := sha256.Sum256([]byte(token))
+	entry := &tokenCacheEntry{key: key, valid: valid, expiresAt: c.now().Add(ttl)}
+	if user != nil {
+		entry.user = *user
+		entry.user.Scopes = slices.Clone(user.Scopes)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.config.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+// deleteHash forgets the result for the token with the given SHA-256 hash,
+// e.g. of an API key that was revoked.
+func (c *tokenCache) deleteHash(key [sha256.Size]byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,57 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	cache := newTokenCache(tokenCacheConfig{size: 2, ttl: time.Minute, negativeTTL: 10 * time.Second})
+	cache.now = func() time.Time { return now }
+
+	cache.put("a", &User{ID: "1"}, true)
+	cache.put("bad", nil, false)
+
+	if user, valid, ok := cache.get("a"); !ok || !valid || user.ID != "1" {
+		t.Errorf("get(a) = %v, %v, %v", user, valid, ok)
+	}
+	if _, valid, ok := cache.get("bad"); !ok || valid {
+		t.Errorf("get(bad) = %v, %v, want a cached invalid token", valid, ok)
+	}
+
+	// Invalid tokens expire sooner.
+	now = now.Add(30 * time.Second)
+	if _, _, ok := cache.get("bad"); ok {
+		t.Error("invalid token must expire after the negative TTL")
+	}
+	if _, _, ok := cache.get("a"); !ok {
+		t.Error("valid token must be kept for the TTL")
+	}
+
+	// The least recently used token is evicted.
+	cache.put("b", &User{ID: "2"}, true)
+	cache.get("a")
+	cache.put("c", &User{ID: "3"}, true)
+	if _, _, ok := cache.get("b"); ok {
+		t.Error("b must have been evicted")
+	}
+	if _, _, ok := cache.get("a"); !ok {
+		t.Error("a must still be cached")
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, ok := cache.get("a"); ok {
+		t.Error("valid token must expire after the TTL")
+	}
+
+	// API keys are trusted for a shorter time, so that revoking them
+	// takes effect on every instance soon.
+	cache = newTokenCache(tokenCacheConfig{size: 2, ttl: time.Minute, negativeTTL: 10 * time.Second, apiKeyTTL: 5 * time.Second})
+	cache.now = func() time.Time { return now }
+	cache.put("key", &User{ID: "1", APIKeyID: "k"}, true)
+	now = now.Add(6 * time.Second)
+	if _, _, ok := cache.get("key"); ok {
+		t.Error("API key must expire after the API key TTL")
+	}
+}
@@ -6,13 +6,23 @@ const (
 )
 
 const (
-	TEST      = BASE + "/test"
-	EA        = BASE + "/ea"
-	GP        = BASE + "/gp"
-	ML        = BASE + "/ml"
-	PSO       = BASE + "/pso"
-	RUNS      = BASE + "/runs"
-	SHARE_RUN = RUNS + "/share"
-	RUN       = RUNS + "/run"
-	LOGS      = RUNS + "/logs"
+	TEST       = BASE + "/test"
+	ALGORITHM  = BASE + "/{type}" // ea, gp, ml, pso or any other registered algorithm type.
+	PREVIEW    = ALGORITHM + "/preview"
+	RUNS       = BASE + "/runs"
+	SHARE_RUN  = RUNS + "/share"
+	CANCEL_RUN = RUNS + "/cancel"
+	CLONE_RUN  = RUNS + "/clone"
+	RUN        = RUNS + "/run"
+	RUN_STATUS = RUNS + "/status"
+	ARTIFACTS  = RUNS + "/artifacts"
+	LOGS       = RUNS + "/logs"
+	LOGS_TOKEN = LOGS + "/token"
+	LOGS_MUX   = LOGS + "/multiplex"
+	LOGS_FILE  = LOGS + "/download"
+	FILES      = BASE + "/files/" // Followed by the object name, for presigned URLs of the local and memory stores.
+	SWEEPS     = BASE + "/sweeps"
+	SWEEP      = SWEEPS + "/{id}"
+	API_KEYS   = BASE + "/keys"
+	API_KEY    = API_KEYS + "/{id}"
 )
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// localStore keeps objects as files in a directory, e.g. for development
+// without minIO. Presigned URLs point to the file route of this service.
+type localStore struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStore returns a store that keeps objects under dir,
+// which is created if it does not exist.
+func NewLocalStore(dir string, baseURL string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: dir, baseURL: baseURL}, nil
+}
+
+// path returns the file of the object, which must be inside the directory.
+func (l *localStore) path(objectName string) (string, error) {
+	clean := path.Clean("/" + objectName)
+	if clean == "/" || clean != "/"+objectName {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
+
+func (l *localStore) Put(ctx context.Context, objectName string, r io.Reader, size int64, contentType string) error {
+	file, err := l.path(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	// Written next to the file and renamed, so readers never see part of it.
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (l *localStore) Get(ctx context.Context, objectName string) ([]byte, error) {
+	file, err := l.path(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return content, err
+}
+
+func (l *localStore) Delete(ctx context.Context, objectName string) error {
+	file, err := l.path(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *localStore) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	// Only the directory the prefix ends in has to be walked.
+	root := l.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := l.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		root = dir
+	}
+
+	files := []FileInfo{}
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return err
+		}
+
+		rel, err := filepath.Rel(l.dir, file)
+		if err != nil {
+			return err
+		}
+		objectName := filepath.ToSlash(rel)
+		if !strings.HasPrefix(objectName, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Name:         strings.TrimPrefix(objectName, prefix),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+func (l *localStore) PresignGet(ctx context.Context, objectName string, expires time.Duration) (string, error) {
+	if _, err := l.path(objectName); err != nil {
+		return "", err
+	}
+	return signedURL(l.baseURL, objectName, expires), nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	content      []byte
+	lastModified time.Time
+}
+
+// memoryStore keeps objects in memory, e.g. for tests. Presigned URLs
+// point to the file route of this service.
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	baseURL string
+}
+
+// NewMemoryStore returns an empty store that keeps objects in memory.
+func NewMemoryStore(baseURL string) Store {
+	return &memoryStore{objects: map[string]memoryObject{}, baseURL: baseURL}
+}
+
+func (m *memoryStore) Put(ctx context.Context, objectName string, r io.Reader, size int64, contentType string) error {
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[objectName] = memoryObject{content: content, lastModified: time.Now()}
+	return nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, objectName string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[objectName]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(object.content), nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, objectName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, objectName)
+	return nil
+}
+
+func (m *memoryStore) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	files := []FileInfo{}
+	for objectName, object := range m.objects {
+		if strings.HasPrefix(objectName, prefix) {
+			files = append(files, FileInfo{
+				Name:         strings.TrimPrefix(objectName, prefix),
+				Size:         int64(len(object.content)),
+				LastModified: object.lastModified,
+			})
+		}
+	}
+	slices.SortFunc(files, func(a, b FileInfo) int { return strings.Compare(a.Name, b.Name) })
+	return files, nil
+}
+
+func (m *memoryStore) PresignGet(ctx context.Context, objectName string, expires time.Duration) (string, error) {
+	return signedURL(m.baseURL, objectName, expires), nil
+}
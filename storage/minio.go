@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"evolve/util"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// MinioConfig describes the minIO (or any S3 compatible) bucket files are kept in.
+type MinioConfig struct {
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	Region          string
+	Bucket          string
+}
+
+// MinioConfigFromEnv reads the minIO configuration from the environment.
+func MinioConfigFromEnv() MinioConfig {
+	config := MinioConfig{
+		Endpoint:        os.Getenv("MINIO_ENDPOINT"),
+		AccessKeyID:     os.Getenv("MINIO_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("MINIO_SECRET_KEY"),
+		Region:          os.Getenv("MINIO_REGION"),
+		Bucket:          os.Getenv("MINIO_BUCKET"),
+	}
+	config.UseSSL, _ = strconv.ParseBool(os.Getenv("MINIO_USE_SSL"))
+	if config.Bucket == "" {
+		config.Bucket = "code"
+	}
+	return config
+}
+
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewMinioStore connects to minIO and creates the bucket if it does not
+// exist yet. The bucket is private, files are shared with presigned URLs.
+func NewMinioStore(config MinioConfig, logger util.Logger) (Store, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s at %s: %w", config.Bucket, config.Endpoint, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", config.Bucket, err)
+		}
+		logger.Info(fmt.Sprintf("Successfully created bucket %s", config.Bucket))
+	}
+
+	logger.Info(fmt.Sprintf("Successfully connected to minio at %s", config.Endpoint))
+	return &minioStore{client: client, bucket: config.Bucket}, nil
+}
+
+func (m *minioStore) Put(ctx context.Context, objectName string, r io.Reader, size int64, contentType string) error {
+	_, err := m.client.PutObject(ctx, m.bucket, objectName, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (m *minioStore) Get(ctx context.Context, objectName string) ([]byte, error) {
+	object, err := m.client.GetObject(ctx, m.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	// The object is only requested on the first read.
+	content, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (m *minioStore) Delete(ctx context.Context, objectName string) error {
+	return m.client.RemoveObject(ctx, m.bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+func (m *minioStore) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	for object := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		files = append(files, FileInfo{
+			Name:         strings.TrimPrefix(object.Key, prefix),
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+	return files, nil
+}
+
+func (m *minioStore) PresignGet(ctx context.Context, objectName string, expires time.Duration) (string, error) {
+	u, err := m.client.PresignedGetObject(ctx, m.bucket, objectName, expires, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"evolve/routes"
+	"evolve/util"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// filePurpose separates file tokens from other signed tokens.
+const filePurpose = "file"
+
+// publicURL is the address clients reach this service at, used for the
+// presigned URLs of stores that are served by the service itself.
+func publicURL() string {
+	if value := os.Getenv("STORAGE_PUBLIC_URL"); value != "" {
+		return strings.TrimSuffix(value, "/")
+	}
+
+	port := os.Getenv("HTTP_PORT")
+	if port == "" {
+		port = "5002"
+	}
+	return fmt.Sprintf("http://localhost:%s", port)
+}
+
+// signedURL returns a URL of the file route carrying
+// a token for the object that expires after the duration.
+func signedURL(baseURL string, objectName string, expires time.Duration) string {
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	token := util.Sign(filePurpose, objectName, time.Now().Add(expires))
+	return baseURL + routes.FILES + strings.Join(segments, "/") + "?token=" + url.QueryEscape(token)
+}
+
+// VerifyFileToken checks that the token of a signed URL was issued for the object.
+func VerifyFileToken(objectName string, token string) error {
+	value, err := util.Verify(filePurpose, token)
+	if err != nil {
+		return err
+	}
+	if value != objectName {
+		return util.ErrInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package storage keeps the files of runs (code, input, logs and whatever
+// the scripts write) in an object store shared by the whole service:
+// minIO, a local directory or memory, selected with STORAGE_BACKEND.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"evolve/util"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Storage backends.
+const (
+	BackendMinio  = "minio"
+	BackendLocal  = "local"
+	BackendMemory = "memory"
+)
+
+// ErrNotFound is returned by Get if the object does not exist.
+var ErrNotFound = errors.New("file not found")
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	Name         string // Name relative to the listed prefix, e.g. logbook.txt.
+	Size         int64
+	LastModified time.Time
+}
+
+// Store is an object store. Object names are slash separated,
+// e.g. <runID>/code.py.
+type Store interface {
+	// Put stores size bytes read from r as the object, or
+	// everything until EOF if size is -1.
+	Put(ctx context.Context, objectName string, r io.Reader, size int64, contentType string) error
+	// Get reads the object, or returns ErrNotFound.
+	Get(ctx context.Context, objectName string) ([]byte, error)
+	Delete(ctx context.Context, objectName string) error
+	// List lists the objects whose name starts with the prefix.
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+	// PresignGet returns a URL that allows anyone to
+	// download the object until it expires.
+	PresignGet(ctx context.Context, objectName string, expires time.Duration) (string, error)
+}
+
+var store Store
+
+// Init creates the store selected by STORAGE_BACKEND (minio by default)
+// from the environment.
+func Init(logger util.Logger) error {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = BackendMinio
+	}
+
+	var err error
+	switch backend {
+	case BackendMinio:
+		store, err = NewMinioStore(MinioConfigFromEnv(), logger)
+	case BackendLocal:
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "data"
+			logger.Warn(fmt.Sprintf("STORAGE_DIR not set, using default: %s", dir))
+		}
+		store, err = NewLocalStore(dir, publicURL())
+	case BackendMemory:
+		logger.Warn("Using the in-memory store, files are lost when the service stops.")
+		store = NewMemoryStore(publicURL())
+	default:
+		err = fmt.Errorf("unknown STORAGE_BACKEND %q, expected %s, %s or %s", backend, BackendMinio, BackendLocal, BackendMemory)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize %s store: %v", backend, err))
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Using the %s store.", backend))
+	return nil
+}
+
+// Use replaces the store, e.g. with a memory store in tests.
+func Use(s Store) {
+	store = s
+}
+
+// ObjectName returns the name of a file of a run, e.g. <runID>/code.py.
+func ObjectName(runID string, fileName string, extension string) string {
+	return fmt.Sprintf("%s/%s.%s", runID, fileName, extension)
+}
+
+// PutBytes stores the content as the object.
+func PutBytes(ctx context.Context, objectName string, content []byte) error {
+	return PutReader(ctx, objectName, bytes.NewReader(content), int64(len(content)))
+}
+
+// PutReader stores size bytes read from r as the object.
+// A size of -1 reads r until EOF.
+func PutReader(ctx context.Context, objectName string, r io.Reader, size int64) error {
+	var logger = util.NewLogger()
+
+	if err := store.Put(ctx, objectName, r, size, ContentType(objectName)); err != nil {
+		logger.Error(fmt.Sprintf("Failed to upload %s: %v", objectName, err))
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Successfully uploaded %s", objectName))
+	return nil
+}
+
+// Get reads the object.
+func Get(ctx context.Context, objectName string) ([]byte, error) {
+	var logger = util.NewLogger()
+
+	content, err := store.Get(ctx, objectName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Error(fmt.Sprintf("Failed to download %s: %v", objectName, err))
+	}
+	return content, err
+}
+
+// Delete removes the object.
+func Delete(ctx context.Context, objectName string) error {
+	var logger = util.NewLogger()
+
+	if err := store.Delete(ctx, objectName); err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete %s: %v", objectName, err))
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Successfully deleted %s", objectName))
+	return nil
+}
+
+// List lists the objects under the prefix, e.g. <runID>/.
+func List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var logger = util.NewLogger()
+
+	files, err := store.List(ctx, prefix)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to list %s: %v", prefix, err))
+		return nil, err
+	}
+	return files, nil
+}
+
+// PresignGet returns a URL that allows anyone to
+// download the object until it expires.
+func PresignGet(ctx context.Context, objectName string, expires time.Duration) (string, error) {
+	var logger = util.NewLogger()
+
+	url, err := store.PresignGet(ctx, objectName, expires)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to presign %s: %v", objectName, err))
+		return "", err
+	}
+	return url, nil
+}
+
+// ContentType guesses the content type of the object from its extension.
+func ContentType(objectName string) string {
+	switch {
+	case strings.HasSuffix(objectName, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(objectName, ".py"):
+		return "text/x-python"
+	}
+	if t := mime.TypeByExtension(path.Ext(objectName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
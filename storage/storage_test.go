@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	local, err := NewLocalStore(t.TempDir(), "http://localhost:5002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]Store{
+		BackendLocal:  local,
+		BackendMemory: NewMemoryStore("http://localhost:5002"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, objectName := range []string{"run/code.py", "run/plots/graph.png", "other/code.py"} {
+				if err := store.Put(ctx, objectName, strings.NewReader(objectName+" content"), -1, ContentType(objectName)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			content, err := store.Get(ctx, "run/code.py")
+			if err != nil || string(content) != "run/code.py content" {
+				t.Errorf("Get = %q, %v", content, err)
+			}
+			if _, err := store.Get(ctx, "run/missing.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get of a missing object = %v, want ErrNotFound", err)
+			}
+
+			files, err := store.List(ctx, "run/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name)
+			}
+			if want := []string{"code.py", "plots/graph.png"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("List = %v, want %v", names, want)
+			}
+
+			if err := store.Delete(ctx, "run/code.py"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(ctx, "run/code.py"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+			}
+
+			presigned, err := store.PresignGet(ctx, "run/plots/graph.png", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(presigned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyFileToken("run/plots/graph.png", u.Query().Get("token")); err != nil {
+				t.Errorf("VerifyFileToken = %v", err)
+			}
+			if err := VerifyFileToken("other/code.py", u.Query().Get("token")); err == nil {
+				t.Error("token must only be valid for the presigned object")
+			}
+		})
+	}
+}
+
+func TestLocalStoreRejectsEscapingNames(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:5002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, objectName := range []string{"../secret", "run/../../secret", "/abs", ""} {
+		if err := store.Put(context.Background(), objectName, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("Put(%q) must fail", objectName)
+		}
+	}
+}
@@ -3,52 +3,420 @@ package util
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
-func EnqueueRunRequest(ctx context.Context, runID string, fileName string, extension string) error {
-	var logger = NewLogger()
+// RunMessage represents the structure of the message
+// runners receive for every queued run.
+type RunMessage struct {
+	RunId     string    `json:"runId"`
+	FileName  string    `json:"fileName"`
+	Extension string    `json:"extension"`
+	Attempt   int       `json:"attempt"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RunQueueConfig describes the Redis Stream runs are queued on.
+//
+// Runners read the stream through the consumer group, XACK a message
+// once the run has finished and must keep long running messages alive
+// (e.g. XCLAIM to themselves) more often than the visibility timeout.
+// Messages idle for longer are redelivered until MaxAttempts is reached
+// and are then moved to the dead-letter stream.
+type RunQueueConfig struct {
+	Stream            string
+	Group             string
+	DeadLetter        string
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+}
+
+const (
+	runMessageField  = "message" // Stream field holding the JSON encoded RunMessage.
+	reasonField      = "reason"  // Dead-letter field holding the failure reason.
+	reclaimBatchSize = 100       // How many pending messages to inspect per XPENDING call.
+)
+
+var (
+	runQueueConfig     RunQueueConfig
+	runQueueConfigOnce sync.Once
+)
+
+// RunQueue returns the run queue configuration read from the environment.
+func RunQueue() RunQueueConfig {
+	runQueueConfigOnce.Do(func() {
+		var logger = NewLogger()
+
+		runQueueConfig = RunQueueConfig{
+			Stream:            os.Getenv("REDIS_QUEUE_NAME"),
+			Group:             os.Getenv("REDIS_QUEUE_GROUP"),
+			DeadLetter:        os.Getenv("REDIS_QUEUE_DEAD_LETTER"),
+			VisibilityTimeout: 5 * time.Minute,
+			MaxAttempts:       3,
+		}
+
+		if runQueueConfig.Stream == "" {
+			runQueueConfig.Stream = "task_queue"
+			logger.Warn(fmt.Sprintf("REDIS_QUEUE_NAME not set, using default: %s", runQueueConfig.Stream))
+		}
+		if runQueueConfig.Group == "" {
+			runQueueConfig.Group = "runners"
+		}
+		if runQueueConfig.DeadLetter == "" {
+			runQueueConfig.DeadLetter = runQueueConfig.Stream + ":dead"
+		}
 
-	// Message represents the structure of our message
-	type Message struct {
-		RunId     string    `json:"runId"`
-		FileName  string    `json:"fileName"`
-		Extension string    `json:"extension"`
-		Timestamp time.Time `json:"timestamp"`
+		if timeout := os.Getenv("REDIS_QUEUE_VISIBILITY_TIMEOUT"); timeout != "" {
+			if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+				runQueueConfig.VisibilityTimeout = d
+			} else {
+				logger.Warn(fmt.Sprintf("Invalid REDIS_QUEUE_VISIBILITY_TIMEOUT '%s', using default: %s", timeout, runQueueConfig.VisibilityTimeout))
+			}
+		}
+
+		if attempts := os.Getenv("REDIS_QUEUE_MAX_ATTEMPTS"); attempts != "" {
+			if n, err := strconv.Atoi(attempts); err == nil && n > 0 {
+				runQueueConfig.MaxAttempts = n
+			} else {
+				logger.Warn(fmt.Sprintf("Invalid REDIS_QUEUE_MAX_ATTEMPTS '%s', using default: %d", attempts, runQueueConfig.MaxAttempts))
+			}
+		}
+	})
+	return runQueueConfig
+}
+
+// migrateRunQueueScript turns the list runs used to be pushed to into the
+// run stream, oldest message first, in one step so that no message is lost
+// or read twice. It returns the number of migrated messages.
+var migrateRunQueueScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1]).ok ~= 'list' then
+	return 0
+end
+local messages = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1])
+for i = #messages, 1, -1 do
+	redis.call('XADD', KEYS[1], '*', ARGV[1], messages[i])
+end
+return #messages
+`)
+
+// InitRunQueue creates the run stream and its consumer group if they do not
+// exist. A list left at the stream key by an older version of the service
+// is migrated to the stream first.
+func InitRunQueue(logger Logger) error {
+	queue := RunQueue()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	migrated, err := migrateRunQueueScript.Run(ctx, RedisClient, []string{queue.Stream}, runMessageField).Int64()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to migrate run queue %s to a stream: %v", queue.Stream, err))
+		return err
+	}
+	if migrated > 0 {
+		logger.Info(fmt.Sprintf("Migrated %d queued runs from the list %s to a stream", migrated, queue.Stream))
 	}
 
-	// Declare a queue
-	queueName := os.Getenv("REDIS_QUEUE_NAME")
-	if queueName == "" {
-		queueName = "task_queue"
-		logger.Warn(fmt.Sprintf("REDIS_QUEUE_NAME not set, using default: %s", queueName))
+	err = RedisClient.XGroupCreateMkStream(ctx, queue.Stream, queue.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		logger.Error(fmt.Sprintf("Failed to create consumer group %s on %s: %v", queue.Group, queue.Stream, err))
+		return err
 	}
 
+	logger.Info(fmt.Sprintf("Run queue ready: stream=%s group=%s deadLetter=%s", queue.Stream, queue.Group, queue.DeadLetter))
+	return nil
+}
+
+func EnqueueRunRequest(ctx context.Context, runID string, fileName string, extension string) error {
+	var logger = NewLogger()
+
 	// Create a new message
-	msg := Message{
+	msg := RunMessage{
 		RunId:     runID,
 		FileName:  fileName,
 		Extension: extension,
+		Attempt:   1,
 		Timestamp: time.Now(),
 	}
 
+	if err := addRunMessage(ctx, RedisClient, RunQueue().Stream, msg, nil); err != nil {
+		logger.Error(fmt.Sprintf("Failed to publish message: %v", err))
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Published message: %s", msg.RunId))
+	return nil
+}
+
+// addRunMessage appends a message to the given stream.
+func addRunMessage(ctx context.Context, rdb redis.Cmdable, stream string, msg RunMessage, extra map[string]any) error {
 	// Convert message to JSON
 	body, err := json.Marshal(msg)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error marshaling message: %v", err))
 		return err
 	}
 
-	// Push message to Redis List (LPUSH = enqueue at head)
-	err = RedisClient.LPush(ctx, queueName, string(body)).Err();
+	values := map[string]any{runMessageField: string(body)}
+	for k, v := range extra {
+		values[k] = v
+	}
+
+	return rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}).Err()
+}
+
+// parseRunMessage decodes the RunMessage stored in a stream entry.
+func parseRunMessage(entry redis.XMessage) (RunMessage, error) {
+	var msg RunMessage
+	body, ok := entry.Values[runMessageField].(string)
+	if !ok {
+		return msg, fmt.Errorf("entry %s has no %s field", entry.ID, runMessageField)
+	}
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return msg, err
+	}
+	// Messages migrated from the old list queue were never counted.
+	if msg.Attempt == 0 {
+		msg.Attempt = 1
+	}
+	return msg, nil
+}
+
+// dequeueRunScript deletes a queue entry unless it is in the pending
+// entries list of the group, in one step so that no runner can read the
+// entry in between. It returns the number of deleted entries.
+var dequeueRunScript = redis.NewScript(`
+if #redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[2], ARGV[2], 1) > 0 then
+	return 0
+end
+return redis.call('XDEL', KEYS[1], ARGV[2])
+`)
+
+// DequeueRunRequest removes a run that is still waiting in the queue.
+// It returns false if no undelivered message was found for the run,
+// which means a runner has already picked it up (or it never existed).
+func DequeueRunRequest(ctx context.Context, runID string) (bool, error) {
+	var logger = NewLogger()
+	queue := RunQueue()
+
+	start := "-"
+	for {
+		entries, err := RedisClient.XRangeN(ctx, queue.Stream, start, "+", reclaimBatchSize).Result()
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to read queue %s: %v", queue.Stream, err))
+			return false, err
+		}
+
+		for _, entry := range entries {
+			msg, err := parseRunMessage(entry)
+			if err != nil || msg.RunId != runID {
+				continue
+			}
+
+			removed, err := dequeueRunScript.Run(ctx, RedisClient, []string{queue.Stream}, queue.Group, entry.ID).Int64()
+			if err != nil {
+				logger.Error(fmt.Sprintf("Failed to remove message for %s: %v", runID, err))
+				return false, err
+			}
 
+			// Messages in the pending entries list are being worked on, or
+			// the entry is already gone. Either way the caller must signal
+			// the runner instead.
+			if removed == 0 {
+				return false, nil
+			}
+
+			logger.Info(fmt.Sprintf("Removed queued message: %s", runID))
+			return true, nil
+		}
+
+		if len(entries) < reclaimBatchSize {
+			return false, nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// ReclaimRunMessages redelivers messages that have not been acknowledged
+// within the visibility timeout. Messages that already used all their
+// attempts are moved to the dead-letter stream and onDeadLetter is called
+// with the message and the reason it was dead-lettered.
+func ReclaimRunMessages(ctx context.Context, onDeadLetter func(msg RunMessage, reason string)) error {
+	var logger = NewLogger()
+	queue := RunQueue()
+
+	pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: queue.Stream,
+		Group:  queue.Group,
+		Idle:   queue.VisibilityTimeout,
+		Start:  "-",
+		End:    "+",
+		Count:  reclaimBatchSize,
+	}).Result()
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to publish message: %v", err))
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
 		return err
 	}
 
-	logger.Info(fmt.Sprintf("Published message: %s", msg.RunId))
+	for _, p := range pending {
+		entries, err := RedisClient.XRange(ctx, queue.Stream, p.ID, p.ID).Result()
+		if err != nil {
+			return err
+		}
+
+		// The entry was deleted (e.g. cancelled), only clear the pending entry.
+		if len(entries) == 0 {
+			if err := RedisClient.XAck(ctx, queue.Stream, queue.Group, p.ID).Err(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		msg, err := parseRunMessage(entries[0])
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Dropping malformed queue entry %s: %v", p.ID, err))
+			if err := ackAndDelete(ctx, RedisClient, queue, p.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Do not redeliver runs that were cancelled while running.
+		cancelled, err := RedisClient.Exists(ctx, CancelKey(msg.RunId)).Result()
+		if err != nil {
+			return err
+		}
+		if cancelled > 0 {
+			if err := ackAndDelete(ctx, RedisClient, queue, p.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.Attempt >= queue.MaxAttempts {
+			reason := fmt.Sprintf("run was not acknowledged by a runner after %d attempts", msg.Attempt)
+			_, err = RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if err := addRunMessage(ctx, pipe, queue.DeadLetter, msg, map[string]any{reasonField: reason}); err != nil {
+					return err
+				}
+				return ackAndDelete(ctx, pipe, queue, p.ID)
+			})
+			if err != nil {
+				return err
+			}
+
+			logger.Warn(fmt.Sprintf("Dead-lettered message: %s (%s)", msg.RunId, reason))
+			onDeadLetter(msg, reason)
+			continue
+		}
+
+		msg.Attempt++
+		_, err = RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := addRunMessage(ctx, pipe, queue.Stream, msg, nil); err != nil {
+				return err
+			}
+			return ackAndDelete(ctx, pipe, queue, p.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info(fmt.Sprintf("Redelivered message: %s (attempt %d of %d)", msg.RunId, msg.Attempt, queue.MaxAttempts))
+	}
+
+	return nil
+}
+
+// TrimRunQueue removes the messages every runner is done with from the run
+// stream. Runners only XACK messages, so without trimming the stream keeps
+// every run ever queued. Messages before the oldest pending message, or
+// before the last delivered one if none are pending, have all been
+// acknowledged. It returns the number of removed messages.
+func TrimRunQueue(ctx context.Context) (int64, error) {
+	queue := RunQueue()
+
+	groups, err := RedisClient.XInfoGroups(ctx, queue.Stream).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	minID := ""
+	for _, group := range groups {
+		if group.Name != queue.Group {
+			continue
+		}
+		minID = group.LastDeliveredID
+		if group.Pending > 0 {
+			pending, err := RedisClient.XPending(ctx, queue.Stream, queue.Group).Result()
+			if err != nil {
+				return 0, err
+			}
+			minID = pending.Lower
+		}
+	}
+	if minID == "" || minID == "0-0" {
+		return 0, nil
+	}
+
+	return RedisClient.XTrimMinID(ctx, queue.Stream, minID).Result()
+}
+
+// ackAndDelete acknowledges a message and removes it from the run stream.
+func ackAndDelete(ctx context.Context, rdb redis.Cmdable, queue RunQueueConfig, id string) error {
+	if err := rdb.XAck(ctx, queue.Stream, queue.Group, id).Err(); err != nil {
+		return err
+	}
+	return rdb.XDel(ctx, queue.Stream, id).Err()
+}
+
+// PublishRunCancel signals runners that a run which has already been
+// picked up should be stopped. The signal is published on
+// REDIS_CANCEL_CHANNEL and also kept as a key for runners that poll.
+func PublishRunCancel(ctx context.Context, runID string) error {
+	var logger = NewLogger()
+
+	channel := os.Getenv("REDIS_CANCEL_CHANNEL")
+	if channel == "" {
+		channel = "run_cancel"
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"runId":     runID,
+		"timestamp": time.Now(),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshaling cancel message: %v", err))
+		return err
+	}
+
+	if err := RedisClient.Set(ctx, CancelKey(runID), string(body), 24*time.Hour).Err(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to set cancel key for %s: %v", runID, err))
+		return err
+	}
+
+	if err := RedisClient.Publish(ctx, channel, string(body)).Err(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to publish cancel signal for %s: %v", runID, err))
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Published cancel signal: %s", runID))
 	return nil
 }
+
+// CancelKey is the Redis key runners can check to see if a run was cancelled.
+func CancelKey(runID string) string {
+	return fmt.Sprintf("cancel:%s", runID)
+}
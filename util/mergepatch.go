@@ -0,0 +1,29 @@
+package util
+
+// MergePatch applies a JSON merge patch (RFC 7386) to a decoded JSON
+// document. Objects are merged recursively, null removes a member and
+// any other value replaces the target. The target is not modified.
+func MergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	result := make(map[string]any, len(targetObject)+len(patchObject))
+	for key, value := range targetObject {
+		result[key] = value
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = MergePatch(result[key], value)
+	}
+	return result
+}
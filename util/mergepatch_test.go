@@ -0,0 +1,66 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{"replace a member", `{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{"add a member", `{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{"null deletes a member", `{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{"null deletes a missing member", `{"a": "b"}`, `{"c": null}`, `{"a": "b"}`},
+		{"merge nested objects", `{"a": {"b": "c", "d": "e"}}`, `{"a": {"d": "f", "g": "h"}}`, `{"a": {"b": "c", "d": "f", "g": "h"}}`},
+		{"null deletes a nested member", `{"a": {"b": "c", "d": "e"}}`, `{"a": {"b": null}}`, `{"a": {"d": "e"}}`},
+		{"object replaces a value", `{"a": "b"}`, `{"a": {"c": "d"}}`, `{"a": {"c": "d"}}`},
+		{"value replaces an object", `{"a": {"b": "c"}}`, `{"a": 1}`, `{"a": 1}`},
+		{"arrays are replaced", `{"a": [1, 2, 3]}`, `{"a": [4]}`, `{"a": [4]}`},
+		{"arrays of objects are replaced", `{"a": [{"b": "c"}]}`, `{"a": [{"d": "e"}]}`, `{"a": [{"d": "e"}]}`},
+		{"empty patch", `{"a": "b"}`, `{}`, `{"a": "b"}`},
+		{"non-object patch replaces the target", `{"a": "b"}`, `["c"]`, `["c"]`},
+		{"null patch replaces the target", `{"a": "b"}`, `null`, `null`},
+		{"non-object target", `["a"]`, `{"b": "c"}`, `{"b": "c"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, patch, want := decode(t, tt.target), decode(t, tt.patch), decode(t, tt.want)
+			before := decode(t, tt.target)
+
+			got := MergePatch(target, patch)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("MergePatch(%s, %s) = %v, want %v", tt.target, tt.patch, got, want)
+			}
+			if !reflect.DeepEqual(target, before) {
+				t.Errorf("MergePatch modified the target: %v, was %v", target, before)
+			}
+		})
+	}
+}
+
+// Clones without overrides decode to a nil map, which must leave the input as it is.
+func TestMergePatchAbsentOverrides(t *testing.T) {
+	target := map[string]any{"a": "b", "c": map[string]any{"d": 1.0}}
+
+	var overrides map[string]any
+	got := MergePatch(target, overrides)
+	if !reflect.DeepEqual(got, target) {
+		t.Errorf("MergePatch(%v, nil map) = %v, want the target", target, got)
+	}
+}
+
+func decode(t *testing.T, doc string) any {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", doc, err)
+	}
+	return v
+}
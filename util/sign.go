@@ -0,0 +1,83 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+var (
+	signingSecret     []byte
+	signingSecretOnce sync.Once
+)
+
+// secret returns the key used to sign tokens, read from SIGNING_SECRET.
+// Without it a random key is used, so tokens are only accepted by the
+// instance that issued them and only until it restarts.
+func secret() []byte {
+	signingSecretOnce.Do(func() {
+		if value := os.Getenv("SIGNING_SECRET"); value != "" {
+			signingSecret = []byte(value)
+			return
+		}
+
+		var logger = NewLogger()
+		logger.Warn("SIGNING_SECRET not set, using a random key. Signed tokens will not be accepted by other instances.")
+		signingSecret = make([]byte, 32)
+		rand.Read(signingSecret)
+	})
+	return signingSecret
+}
+
+// Sign returns a token carrying value that expires at expiresAt.
+// The purpose is part of the signature, so a token issued for
+// one purpose is not accepted for another.
+func Sign(purpose string, value string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + signature(purpose, payload)
+}
+
+// Verify checks a token returned by Sign for the same purpose and returns its value.
+func Verify(purpose string, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(signature(purpose, payload))) {
+		return "", ErrInvalidToken
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", ErrTokenExpired
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	return string(value), nil
+}
+
+func signature(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, secret())
+	mac.Write([]byte(purpose + "\n" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
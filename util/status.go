@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RunStatusEvent is a change in the lifecycle of a run. Runners publish
+// it as JSON on the status channel, e.g.
+//
+//	{"runId": "...", "status": "started"}
+//	{"runId": "...", "status": "progress", "progress": {"gen": 3}}
+//	{"runId": "...", "status": "failed", "reason": "exit status 1"}
+type RunStatusEvent struct {
+	RunID     string         `json:"runId"`
+	Status    string         `json:"status"`
+	Reason    string         `json:"reason,omitempty"`
+	Progress  map[string]any `json:"progress,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// StatusEventField is the stream field holding the JSON encoded RunStatusEvent.
+const StatusEventField = "event"
+
+const (
+	statusStreamPrefix    = "status:"          // Prefix of the per-run status streams.
+	statusStreamMaxLen    = 1000               // Approximate number of events kept per run.
+	statusStreamTTL       = 7 * 24 * time.Hour // How long the status stream of a run is kept after its last event.
+	statusDedupeTTL       = 5 * time.Minute    // How long a status message is remembered as handled.
+	statusDedupeKeyPrefix = "status:seen:"     // Prefix of the keys marking handled status messages.
+)
+
+var (
+	runStatusChannel     string
+	runStatusChannelOnce sync.Once
+)
+
+// RunStatusChannel returns the Pub/Sub channel runners publish
+// status events on, read from REDIS_STATUS_CHANNEL.
+func RunStatusChannel() string {
+	runStatusChannelOnce.Do(func() {
+		runStatusChannel = os.Getenv("REDIS_STATUS_CHANNEL")
+		if runStatusChannel == "" {
+			runStatusChannel = "run_status"
+		}
+	})
+	return runStatusChannel
+}
+
+// RunStatusStream returns the key of the stream the status events of a run are kept in.
+func RunStatusStream(runID string) string {
+	return statusStreamPrefix + runID
+}
+
+// SubscribeRunStatus subscribes to the status channel.
+// The caller must close the returned subscription.
+func SubscribeRunStatus(ctx context.Context) *redis.PubSub {
+	return RedisClient.Subscribe(ctx, RunStatusChannel())
+}
+
+// ClaimRunStatusMessage reports whether this instance is the first one to
+// handle the message. Every instance receives every message published on
+// the status channel, but only one of them may record it.
+func ClaimRunStatusMessage(ctx context.Context, payload string) (bool, error) {
+	sum := sha256.Sum256([]byte(payload))
+	return RedisClient.SetNX(ctx, statusDedupeKeyPrefix+hex.EncodeToString(sum[:]), 1, statusDedupeTTL).Result()
+}
+
+// AppendRunStatusEvent adds the event to the status stream of its run,
+// which is what clients of the status stream read.
+func AppendRunStatusEvent(ctx context.Context, event RunStatusEvent) error {
+	var logger = NewLogger()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshaling status event: %v", err))
+		return err
+	}
+
+	stream := RunStatusStream(event.RunID)
+	pipe := RedisClient.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: statusStreamMaxLen,
+		Approx: true,
+		Values: map[string]any{StatusEventField: string(body)},
+	})
+	pipe.Expire(ctx, stream, statusStreamTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error(fmt.Sprintf("Failed to add status event for %s: %v", event.RunID, err))
+		return err
+	}
+	return nil
+}
+
+// DeleteRunStatusEvents removes the status stream of a run.
+func DeleteRunStatusEvents(ctx context.Context, runID string) error {
+	return RedisClient.Del(ctx, RunStatusStream(runID)).Err()
+}
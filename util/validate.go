@@ -2,7 +2,9 @@ package util
 
 import (
 	"fmt"
+	"regexp"
 	"slices"
+	"strings"
 )
 
 func ValidateAlgorithmName(algo string) error {
@@ -12,4 +14,140 @@ func ValidateAlgorithmName(algo string) error {
 	return fmt.Errorf("invalid algorithm name: %s", algo)
 }
 
-// TODO: Implement remaining validation functions.
+// FieldError describes a problem with a single field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects every problem found while validating a request,
+// so that they can all be reported at once.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fieldErr := range v {
+		messages[i] = fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Err returns the collected errors, or nil if there are none.
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Add records a problem with a field.
+func (v *ValidationErrors) Add(field string, format string, args ...any) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Positive checks that value is greater than zero.
+func (v *ValidationErrors) Positive(field string, value int) {
+	if value <= 0 {
+		v.Add(field, "must be greater than 0, got %d", value)
+	}
+}
+
+// NonNegative checks that value is not below zero.
+func (v *ValidationErrors) NonNegative(field string, value int) {
+	if value < 0 {
+		v.Add(field, "must not be negative, got %d", value)
+	}
+}
+
+// Probability checks that value is within [0, 1].
+func (v *ValidationErrors) Probability(field string, value float64) {
+	if value < 0 || value > 1 {
+		v.Add(field, "must be a probability between 0 and 1, got %v", value)
+	}
+}
+
+// OneOf checks that value is one of the allowed options.
+func (v *ValidationErrors) OneOf(field string, value string, options []string) {
+	if !slices.Contains(options, value) {
+		v.Add(field, "must be one of %s, got %q", strings.Join(options, ", "), value)
+	}
+}
+
+// Required checks that value is not blank.
+func (v *ValidationErrors) Required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "is required")
+	}
+}
+
+// Weights checks the fitness weights of a spec.
+func (v *ValidationErrors) Weights(field string, weights []float64) {
+	if len(weights) == 0 {
+		v.Add(field, "must contain at least one weight")
+		return
+	}
+	for i, weight := range weights {
+		if weight == 0 {
+			v.Add(fmt.Sprintf("%s[%d]", field, i), "must not be 0")
+		}
+	}
+}
+
+// PythonString checks that value can be embedded in a double-quoted Python string.
+func (v *ValidationErrors) PythonString(field string, value string) {
+	if strings.ContainsAny(value, "\"\\\n\r") {
+		v.Add(field, "must not contain quotes, backslashes or line breaks")
+	}
+}
+
+var pythonIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PythonIdentifier checks that value is a valid Python identifier.
+func (v *ValidationErrors) PythonIdentifier(field string, value string) {
+	if !pythonIdentifier.MatchString(value) {
+		v.Add(field, "must be a valid Python identifier, got %q", value)
+	}
+}
+
+// IsPythonIdentifier reports whether value is a valid Python identifier.
+func IsPythonIdentifier(value string) bool {
+	return pythonIdentifier.MatchString(value)
+}
+
+// MuLambda checks the mu and lambda_ parameters of the (mu, lambda) algorithms.
+func (v *ValidationErrors) MuLambda(algorithm string, mu int, lambda int, cxpb float64, mutpb float64) {
+	if algorithm != "eaMuPlusLambda" && algorithm != "eaMuCommaLambda" {
+		return
+	}
+
+	v.Positive("mu", mu)
+	v.Positive("lambda_", lambda)
+	if algorithm == "eaMuCommaLambda" && lambda < mu {
+		v.Add("lambda_", "must be greater than or equal to mu (%d) for eaMuCommaLambda, got %d", mu, lambda)
+	}
+	if cxpb+mutpb > 1 {
+		v.Add("cxpb", "cxpb + mutpb must not exceed 1 for %s, got %v", algorithm, cxpb+mutpb)
+	}
+}
+
+// Selection checks the selection function and its tournament size.
+func (v *ValidationErrors) Selection(selectionFunction string, tournamentSize int) {
+	v.OneOf("selectionFunction", selectionFunction, SelectionFunctions)
+	if selectionFunction == "selTournament" && tournamentSize <= 0 {
+		v.Add("tournamentSize", "is required and must be greater than 0 for selTournament")
+	}
+}
+
+// SelectionFunctions are the DEAP selection functions the generators support.
+var SelectionFunctions = []string{
+	"selTournament",
+	"selRoulette",
+	"selRandom",
+	"selBest",
+	"selWorst",
+	"selNSGA2",
+	"selSPEA2",
+	"selStochasticUniversalSampling",
+	"selLexicase",
+	"selAutomaticEpsilonLexicase",
+}